@@ -0,0 +1,274 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// access log formats
+const (
+	AccessCommon   = "common"   // NCSA Common Log Format
+	AccessCombined = "combined" // NCSA Combined Log Format
+	AccessJSON     = "json"     // JSON lines
+)
+
+// AccessEntry is the record of one request.
+type AccessEntry struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Query     string        `json:"query,omitempty"`
+	Proto     string        `json:"proto"`
+	Action    string        `json:"action,omitempty"` // the matched "bundle.Controller.Action"
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"` // the body bytes sent, after compressing
+	Latency   time.Duration `json:"latency"`
+	IP        string        `json:"ip"`
+	RequestID string        `json:"requestId,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"userAgent,omitempty"`
+}
+
+// AccessSink receives the access entries, it must be safe for concurrent use.
+type AccessSink interface {
+	Log(e *AccessEntry)
+}
+
+// AccessSinkFunc is an adapter to allow the use of ordinary functions as access sinks.
+type AccessSinkFunc func(e *AccessEntry)
+
+func (f AccessSinkFunc) Log(e *AccessEntry) {
+
+	f(e)
+}
+
+// AddAccessSink adds the sink for recording every request, including static
+// file requests and not found requests.
+//
+// Usage:
+//
+//	file, err := orivil.NewRotatingFile("./log/access.log", 100<<20, 7)
+//	if err != nil {
+//		panic(err)
+//	}
+//	s.AddAccessSink(orivil.NewAccessLog(file, orivil.AccessJSON))
+func (s *Server) AddAccessSink(sink AccessSink) {
+
+	s.accessSinks = append(s.accessSinks, sink)
+}
+
+// logAccess sends the entry of the finished request to the access sinks.
+func (s *Server) logAccess(w *responseWriter, r *http.Request, app *App, start time.Time, size int64) {
+	e := &AccessEntry{
+		Time:      start,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Proto:     r.Proto,
+		Status:    w.Status(),
+		Bytes:     size,
+		Latency:   time.Since(start),
+		RequestID: w.Header().Get(RequestIDHeader),
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+	if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	if app != nil {
+		e.Action = app.Action
+	}
+	if ip, err := GetIp(r); err == nil {
+		e.IP = ip.String()
+	} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		e.IP = host
+	}
+	for _, sink := range s.accessSinks {
+		sink.Log(e)
+	}
+}
+
+// countWriter counts the bytes which are written to the connection, it is
+// under the compressing writer, so the compressed size is logged.
+type countWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *countWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := c.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (c *countWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := c.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (c *countWriter) Unwrap() http.ResponseWriter {
+
+	return c.ResponseWriter
+}
+
+// AccessLog formats the entries and writes them to the writer line by line.
+type AccessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// NewAccessLog creates the access sink, the format could be AccessCommon,
+// AccessCombined or AccessJSON.
+func NewAccessLog(w io.Writer, format string) *AccessLog {
+
+	return &AccessLog{w: w, format: format}
+}
+
+func (l *AccessLog) Log(e *AccessEntry) {
+	var line []byte
+	switch l.format {
+	case AccessJSON:
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	case AccessCombined:
+		line = []byte(commonLine(e) + fmt.Sprintf(" %q %q\n", dash(e.Referer), dash(e.UserAgent)))
+	default:
+		line = []byte(commonLine(e) + "\n")
+	}
+	l.mu.Lock()
+	l.w.Write(line)
+	l.mu.Unlock()
+}
+
+func commonLine(e *AccessEntry) string {
+	uri := e.Path
+	if e.Query != "" {
+		uri += "?" + e.Query
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s`,
+		dash(e.IP), e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, uri, e.Proto, e.Status, size)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// RotatingFile is the log file which rotates when its size exceeded, the old
+// files are renamed to "name.1", "name.2"... and the oldest is removed.
+type RotatingFile struct {
+	mu         sync.Mutex
+	name       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingFile opens the file for appending, zero maxSize means never
+// rotate.
+func NewRotatingFile(name string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{name: name, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.name), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *RotatingFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size+int64(len(b)) > f.maxSize && f.size > 0 {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxBackups > 0 {
+		os.Remove(f.name + "." + strconv.Itoa(f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(f.name+"."+strconv.Itoa(i), f.name+"."+strconv.Itoa(i+1))
+		}
+		if err := os.Rename(f.name, f.name+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.name); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
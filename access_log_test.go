@@ -0,0 +1,54 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newCountedWriters builds the writers as ServeHTTP does with compressing.
+func newCountedWriters() (*httptest.ResponseRecorder, *countWriter, *compressWriter, *responseWriter) {
+	s := &Server{encoders: make(map[string]Encoder, 2)}
+	s.setDefaultEncoders()
+	rec := httptest.NewRecorder()
+	counter := &countWriter{ResponseWriter: rec}
+	cw := &compressWriter{ResponseWriter: counter, server: s, encoding: EncodingGzip, minSize: 10}
+	return rec, counter, cw, newResponseWriter(cw)
+}
+
+func TestCountWriterCompressedSize(t *testing.T) {
+	rec, counter, cw, rw := newCountedWriters()
+	rw.Header().Set("Content-Type", "text/plain")
+	rw.Write(bytes.Repeat([]byte("a"), 4<<10))
+	rw.finish()
+	cw.Close()
+	if rec.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("the response was not compressed")
+	}
+	if counter.n != int64(rec.Body.Len()) || counter.n >= rw.Size() {
+		t.Errorf("counted %d bytes, sent %d bytes, written %d bytes", counter.n, rec.Body.Len(), rw.Size())
+	}
+}
+
+func TestCountWriterForwarding(t *testing.T) {
+	rec, _, _, rw := newCountedWriters()
+	var w http.ResponseWriter = rw
+	w.(http.Flusher).Flush()
+	if !rec.Flushed {
+		t.Errorf("Flush was not forwarded")
+	}
+	if _, _, err := w.(http.Hijacker).Hijack(); err != http.ErrNotSupported {
+		t.Errorf("Hijack() error = %v, want %v", err, http.ErrNotSupported)
+	}
+	if err := w.(http.Pusher).Push("/app.js", nil); err != http.ErrNotSupported {
+		t.Errorf("Push() error = %v, want %v", err, http.ErrNotSupported)
+	}
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		t.Errorf("ResponseController.Flush() error = %v", err)
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"gopkg.in/orivil/router.v0"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Response is the action result which writes the response by itself.
+//
+// Usage:
+//
+//	func (c *Controller) Download() orivil.Response {
+//		return orivil.ResponseFunc(func(app *orivil.App) error {
+//			http.ServeFile(app.Response, app.Request, "report.pdf")
+//			return nil
+//		})
+//	}
+type Response interface {
+	Respond(app *App) error
+}
+
+// ResponseFunc is the function adapter of Response.
+type ResponseFunc func(app *App) error
+
+func (f ResponseFunc) Respond(app *App) error {
+
+	return f(app)
+}
+
+var (
+	contextType         = reflect.TypeOf((*context.Context)(nil)).Elem()
+	requestType         = reflect.TypeOf((*http.Request)(nil))
+	writerType          = reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()
+	paramType           = reflect.TypeOf(router.Param(nil))
+	errorType           = reflect.TypeOf((*error)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// the kinds of the action arguments
+const (
+	argApp = iota
+	argContext
+	argRequest
+	argWriter
+	argParams
+	argService
+	argParam
+	argBind
+)
+
+type actionArg struct {
+	kind    int
+	typ     reflect.Type
+	service string // the service name of argService
+	param   string // the route param name of argParam
+}
+
+// actionSignature is the checked signature of the action method.
+type actionSignature struct {
+	args     []actionArg
+	hasValue bool // returns the value which is rendered or responded
+	hasError bool // returns the error as the last result
+}
+
+// checkAction checks the signature of the action method, the method type has
+// the controller as the first input. The actions could declare the arguments:
+//
+//	*orivil.App, context.Context, *http.Request, http.ResponseWriter, router.Param
+//	the added services, see Server.AddService
+//	the route param of the pattern which has only one param, the string,
+//	number, boolean, time.Time or encoding.TextUnmarshaler types, the patterns
+//	which have more params are bound by a struct with the "param" tags
+//	the struct or the struct pointer which is bound by App.Bind, the "valid"
+//	tag rules are checked here
+//
+// and return nothing, error, T or (T, error). The returned value is responded
+// if it is a Response, otherwise it is rendered by App.Render instead of the
+// view data. The services are not checked unless strict, because they are
+// added when the bundles boot.
+func (s *Server) checkAction(ft reflect.Type, pattern string, strict bool) (*actionSignature, error) {
+	sig := &actionSignature{}
+	params := routeParams(pattern)
+	for i := 1; i < ft.NumIn(); i++ {
+		t := ft.In(i)
+		arg := actionArg{typ: t}
+		service, err := s.declaredService(t)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d(%v): %v", i, t, err)
+		}
+		switch {
+		case t == appType:
+			arg.kind = argApp
+		case t == contextType:
+			arg.kind = argContext
+		case t == requestType:
+			arg.kind = argRequest
+		case t == writerType:
+			arg.kind = argWriter
+		case t == paramType:
+			arg.kind = argParams
+		case service != "":
+			arg.kind, arg.service = argService, service
+		case isParamType(t):
+			if len(params) == 0 {
+				return nil, fmt.Errorf("argument %d(%v) has no route param in pattern %q", i, t, pattern)
+			}
+			if len(params) > 1 {
+				// the arguments are bound in order, the swapped params of the
+				// same type could not be found
+				return nil, fmt.Errorf("argument %d(%v): pattern %q has %d route params, bind them by a struct "+
+					"with the \"param\" tags, e.g. ID int `param:\"%s\"`", i, t, pattern, len(params), params[0])
+			}
+			arg.kind, arg.param, params = argParam, params[0], nil
+		case t.Kind() == reflect.Struct || t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct:
+			arg.kind = argBind
+			if _, err := compileRules(indirectType(t)); err != nil {
+				return nil, fmt.Errorf("argument %d(%v): %v", i, t, err)
+			}
+		case t.Kind() == reflect.Interface && !strict:
+			arg.kind = argService
+		case t.Kind() == reflect.Interface:
+			return nil, fmt.Errorf("argument %d(%v): no service was added", i, t)
+		default:
+			return nil, fmt.Errorf("argument %d(%v) is not supported", i, t)
+		}
+		sig.args = append(sig.args, arg)
+	}
+	switch ft.NumOut() {
+	case 0:
+	case 1:
+		sig.hasError = ft.Out(0) == errorType
+		sig.hasValue = !sig.hasError
+	case 2:
+		if ft.Out(1) != errorType {
+			return nil, fmt.Errorf("the second result must be error, got %v", ft.Out(1))
+		}
+		sig.hasValue, sig.hasError = true, true
+	default:
+		return nil, fmt.Errorf("too many results, the action returns nothing, error, T or (T, error)")
+	}
+	return sig, nil
+}
+
+// declaredService returns the service name which was declared by the type, see
+// Server.AddService. It returns an error if more services were declared by the
+// same concrete type, the argument could not tell which one to use.
+func (s *Server) declaredService(t reflect.Type) (string, error) {
+	if service, ok := s.interfaces[t]; ok {
+		return service, nil
+	}
+	var services []string
+	for service, typ := range s.serviceTypes {
+		if typ == t {
+			services = append(services, service)
+		}
+	}
+	switch len(services) {
+	case 0:
+		return "", nil
+	case 1:
+		return services[0], nil
+	}
+	sort.Strings(services)
+	return "", fmt.Errorf("services %q have the same type, declare an interface type "+
+		"for the one to use, or inject it by the tagged controller field", services)
+}
+
+func isParamType(t reflect.Type) bool {
+	if t == typeTime || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// routeParams returns the param names of the pattern in order.
+func routeParams(pattern string) (names []string) {
+	for _, seg := range strings.Split(pattern, "/") {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			names = append(names, strings.TrimLeft(seg, ":"))
+		case strings.HasPrefix(seg, "*"):
+			names = append(names, seg[1:])
+		}
+	}
+	return
+}
+
+// invoker is the action call which is prepared when the server starts, so the
+// requests do not look up the method, the injections and the middleware.
+type invoker struct {
+	method     reflect.Value // the method function, the controller is the first input
+	signature  *actionSignature
+	injections []injection
+	middles    []string // the middleware services configured by middle.Bag
+}
+
+// loadActions prepares the calls of all actions with the declared services and
+// the configured middleware, it panics if any action can not be called.
+func (s *Server) loadActions(actions map[string]map[string][]string, providers map[string]func() interface{}) {
+	var errs []string
+	for bundle, controllers := range actions {
+		for controller, as := range controllers {
+			t := reflect.TypeOf(providers[bundle+"."+controller]())
+			for _, name := range as {
+				action := bundle + "." + controller + "." + name
+				inv, err := s.newInvoker(action, t)
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %v", action, err))
+					continue
+				}
+				s.invokers[action] = inv
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		panic(fmt.Errorf("action signatures:\n\t%s", strings.Join(errs, "\n\t")))
+	}
+}
+
+// newInvoker prepares the action call of the controller type, the signature is
+// checked against every route pattern of the action.
+func (s *Server) newInvoker(action string, t reflect.Type) (*invoker, error) {
+	_, name := splitAction(action)
+	method, ok := t.MethodByName(name)
+	if !ok {
+		return nil, fmt.Errorf("no such method")
+	}
+	patterns := s.actionPatterns(action)
+	if len(patterns) == 0 {
+		patterns = []string{s.routePaths[action]}
+	}
+	var sig *actionSignature
+	for i, pattern := range patterns {
+		sg, err := s.checkAction(method.Type, pattern, true)
+		if err != nil {
+			return nil, err
+		}
+		if sig != nil {
+			// the arguments are bound by the same params for all routes
+			for j, arg := range sg.args {
+				if arg.param != sig.args[j].param {
+					return nil, fmt.Errorf("argument %d(%v) is bound by param %q in pattern %q, but %q in pattern %q",
+						j+1, arg.typ, sig.args[j].param, patterns[0], arg.param, patterns[i])
+				}
+			}
+		}
+		sig = sg
+	}
+	injections, ok := s.injections[t.Elem()]
+	if !ok {
+		var errs []string
+		if injections, errs = s.planInjections(t.Elem(), nil, t.Elem().Name(), make(map[reflect.Type]bool)); len(errs) > 0 {
+			return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+		}
+	}
+	return &invoker{
+		method:     method.Func,
+		signature:  sig,
+		injections: injections,
+		middles:    s.MContainer.Get(action),
+	}, nil
+}
+
+// actionPatterns returns the patterns of the action routes in order, without
+// the duplicates of the methods.
+func (s *Server) actionPatterns(action string) (patterns []string) {
+	seen := make(map[string]bool)
+	for _, rt := range s.routes {
+		if rt.action == action && !seen[rt.pattern] {
+			seen[rt.pattern] = true
+			patterns = append(patterns, rt.pattern)
+		}
+	}
+	return
+}
+
+// invoker returns the action call which was prepared when the server started,
+// every matched action was prepared, because the routes can not be added after
+// the server started.
+func (s *Server) invoker(action string) *invoker {
+	inv, ok := s.invokers[action]
+	if !ok {
+		panic(fmt.Errorf("%s: the action was not prepared when the server started", action))
+	}
+	return inv
+}
+
+// middlewares returns the middleware instances from the private container, the
+// route group middleware are after the configured middleware.
+func (inv *invoker) middlewares(app *App, routeMiddles []interface{}) []interface{} {
+	middles := make([]interface{}, len(inv.middles), len(inv.middles)+len(routeMiddles))
+	for index, service := range inv.middles {
+		middles[index] = app.Container.Get(service)
+	}
+	for _, middle := range routeMiddles {
+		if service, ok := middle.(string); ok {
+			middle = app.Container.Get(service)
+		}
+		middles = append(middles, middle)
+	}
+	return middles
+}
+
+// call sets the controller dependencies and calls the action method with the
+// arguments, then responds the results.
+func (inv *invoker) call(controller interface{}, app *App) {
+	value := reflect.ValueOf(controller)
+	inject(value.Elem(), inv.injections, app)
+	sig := inv.signature
+	in := make([]reflect.Value, len(sig.args)+1)
+	in[0] = value
+	for i := range sig.args {
+		in[i+1] = sig.args[i].value(app)
+	}
+	out := inv.method.Call(in)
+	if len(out) > 0 {
+		app.respond(sig, out)
+	}
+}
+
+// value returns the argument value for the request.
+func (arg *actionArg) value(app *App) reflect.Value {
+	switch arg.kind {
+	case argApp:
+		return reflect.ValueOf(app)
+	case argContext:
+		return reflect.ValueOf(app.Context())
+	case argRequest:
+		return reflect.ValueOf(app.Request)
+	case argWriter:
+		return reflect.ValueOf(app.Response)
+	case argParams:
+		return reflect.ValueOf(app.Params)
+	case argService:
+		service := app.Container.Get(arg.service)
+		if service == nil {
+			panic(fmt.Errorf("action argument %v: service %q not found", arg.typ, arg.service))
+		}
+		v := reflect.ValueOf(service)
+		if !v.Type().AssignableTo(arg.typ) {
+			panic(fmt.Errorf("action argument %v: service %q(%v) is not assignable", arg.typ, arg.service, v.Type()))
+		}
+		return v
+	case argParam:
+		v := reflect.New(arg.typ).Elem()
+		if value, ok := app.Params[arg.param]; ok {
+			if err := setValue(v, value, ""); err != nil {
+				app.Abort(http.StatusBadRequest, fmt.Sprintf("bad route param %q", arg.param), err)
+			}
+		}
+		return v
+	default: // argBind
+		ptr := reflect.New(indirectType(arg.typ))
+		if err := app.Bind(ptr.Interface()); err != nil {
+			if errs, ok := err.(FieldErrors); ok {
+				app.Abort(http.StatusUnprocessableEntity, "", errs)
+			}
+			app.Abort(http.StatusBadRequest, "", err)
+		}
+		if arg.typ.Kind() == reflect.Ptr {
+			return ptr
+		}
+		return ptr.Elem()
+	}
+}
+
+// respond handles the results of the action, the returned error is handled as
+// panic, the nil value is not rendered.
+func (app *App) respond(sig *actionSignature, out []reflect.Value) {
+	if sig.hasError {
+		if e := out[len(out)-1]; !e.IsNil() {
+			panic(e.Interface().(error))
+		}
+	}
+	if !sig.hasValue {
+		return
+	}
+	v := out[0]
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if v.IsNil() {
+			return
+		}
+	}
+	if r, ok := v.Interface().(Response); ok {
+		app.rendered = true
+		if err := r.Respond(app); err != nil {
+			panic(err)
+		}
+		return
+	}
+	app.Render(v.Interface())
+}
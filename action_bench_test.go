@@ -0,0 +1,85 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"gopkg.in/orivil/middle.v0"
+	"gopkg.in/orivil/router.v0"
+	"gopkg.in/orivil/service.v0"
+	"reflect"
+	"testing"
+)
+
+type benchBase struct {
+	App *App
+}
+
+type benchController struct {
+	benchBase
+	Title string
+	Count int
+}
+
+func (c *benchController) Show(app *App, id int) {
+	c.Count = id
+}
+
+const benchAction = "orivil.benchController.Show"
+
+func newBenchServer(b *testing.B) (*Server, *App) {
+	s := &Server{
+		MContainer:   middle.NewContainer(middle.NewMiddlewareBag(), service.NewPublicContainer()),
+		serviceTypes: make(map[string]reflect.Type),
+		interfaces:   make(map[reflect.Type]string),
+		injections:   make(map[reflect.Type][]injection),
+		invokers:     make(map[string]*invoker),
+		routePaths:   map[string]string{benchAction: "/bench/:id"},
+	}
+	inv, err := s.newInvoker(benchAction, reflect.TypeOf(&benchController{}))
+	if err != nil {
+		b.Fatal(err)
+	}
+	s.invokers[benchAction] = inv
+	return s, &App{Action: benchAction, Params: router.Param{"id": "5"}}
+}
+
+// callByName is the per request call before the invokers were prepared, the
+// method is looked up by name and the controller fields are scanned.
+func (s *Server) callByName(controller reflect.Value, action string, app *App) {
+	_, name := splitAction(action)
+	method, _ := controller.Type().MethodByName(name)
+	t := controller.Elem().Type()
+	injections, _ := s.planInjections(t, nil, t.Name(), make(map[reflect.Type]bool))
+	inject(controller.Elem(), injections, app)
+	sig, err := s.checkAction(method.Type, s.routePaths[action], true)
+	if err != nil {
+		panic(err)
+	}
+	in := make([]reflect.Value, len(sig.args)+1)
+	in[0] = controller
+	for i := range sig.args {
+		in[i+1] = sig.args[i].value(app)
+	}
+	method.Func.Call(in)
+}
+
+func BenchmarkActionCallByName(b *testing.B) {
+	s, app := newBenchServer(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.callByName(reflect.ValueOf(&benchController{}), benchAction, app)
+	}
+}
+
+func BenchmarkActionInvoker(b *testing.B) {
+	s, app := newBenchServer(b)
+	inv := s.invoker(benchAction)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		inv.call(&benchController{}, app)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gopkg.in/orivil/service.v0"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type actionUser struct {
+	ID   int    `param:"id"`
+	Name string `form:"name" valid:"required"`
+}
+
+type actionController struct{}
+
+func (*actionController) Show(ctx context.Context, id int) (*actionUser, error) { return nil, nil }
+func (*actionController) Create(app *App, u *actionUser) error                  { return nil }
+func (*actionController) Posts(uid, pid int)                                    {}
+func (*actionController) Post(id string)                                        {}
+func (*actionController) Bound(u actionUser) Response                           { return nil }
+func (*actionController) Sender(s injectSender)                                 {}
+func (*actionController) Chan(c chan int)                                       {}
+func (*actionController) Pair() (int, int)                                      { return 0, 0 }
+func (*actionController) Triple() (int, int, error)                             { return 0, 0, nil }
+func (*actionController) Mail(m *injectMailer)                                  {}
+func (*actionController) BadRule(v struct {
+	Name string `valid:"unknown"`
+}) {
+}
+
+func TestCheckAction(t *testing.T) {
+	s := newInjectServer()
+	ct := reflect.TypeOf(&actionController{})
+	tests := []struct {
+		method  string
+		pattern string
+		strict  bool
+		err     string
+		kinds   []int
+		value   bool
+		error   bool
+	}{
+		{"Show", "/users/:id", true, "", []int{argContext, argParam}, true, true},
+		{"Create", "/users", true, "", []int{argApp, argBind}, false, true},
+		{"Bound", "/users/:uid/posts/:id", true, "", []int{argBind}, true, false},
+		{"Sender", "/", false, "", []int{argService}, false, false},
+		{"Sender", "/", true, "no service was added", nil, false, false},
+		{"Mail", "/", true, "", []int{argService}, false, false},
+		{"Posts", "/users/:uid/posts/:pid", true, `bind them by a struct with the "param" tags`, nil, false, false},
+		{"Post", "/users/:uid/posts/:id", true, `bind them by a struct with the "param" tags`, nil, false, false},
+		{"Post", "/posts", true, "has no route param", nil, false, false},
+		{"Chan", "/", true, "is not supported", nil, false, false},
+		{"BadRule", "/", true, "unknown validation rule", nil, false, false},
+		{"Pair", "/", true, "the second result must be error", nil, false, false},
+		{"Triple", "/", true, "too many results", nil, false, false},
+	}
+	for _, test := range tests {
+		m, _ := ct.MethodByName(test.method)
+		sig, err := s.checkAction(m.Type, test.pattern, test.strict)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s(%s): error = %v, want %q", test.method, test.pattern, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s(%s): %v", test.method, test.pattern, err)
+			continue
+		}
+		var kinds []int
+		for _, arg := range sig.args {
+			kinds = append(kinds, arg.kind)
+		}
+		if !reflect.DeepEqual(kinds, test.kinds) || sig.hasValue != test.value || sig.hasError != test.error {
+			t.Errorf("%s(%s): kinds = %v, value = %v, error = %v", test.method, test.pattern, kinds, sig.hasValue, sig.hasError)
+		}
+	}
+}
+
+func TestCheckActionAmbiguousService(t *testing.T) {
+	s := newInjectServer()
+	s.AddService("svc.backup", (*injectMailer)(nil), func(c *service.Container) interface{} {
+		return new(injectMailer)
+	})
+	m, _ := reflect.TypeOf(&actionController{}).MethodByName("Mail")
+	_, err := s.checkAction(m.Type, "/", true)
+	if err == nil || !strings.Contains(err.Error(), `services ["svc.backup" "svc.mailer"] have the same type`) {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestNewInvokerPatterns(t *testing.T) {
+	ct := reflect.TypeOf(&actionController{})
+	tests := []struct {
+		patterns []string
+		err      string
+	}{
+		{[]string{"/users/:id", "/people/:id"}, ""},
+		{[]string{"/users/:id", "/users"}, `has no route param in pattern "/users"`},
+		{[]string{"/users/:id", "/people/:pid"}, `bound by param "id" in pattern "/users/:id", but "pid" in pattern "/people/:pid"`},
+		{nil, "has no route param"},
+	}
+	for _, test := range tests {
+		s := newMethodServer(t)
+		for _, pattern := range test.patterns {
+			s.addRoute(&route{method: "GET", pattern: pattern, action: "orivil.actionController.Show"})
+		}
+		_, err := s.newInvoker("orivil.actionController.Show", ct)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%q: error = %v, want %q", test.patterns, err, test.err)
+		}
+	}
+}
+
+func TestServeBindErrors(t *testing.T) {
+	s := newMethodServer(t)
+	s.addRoute(&route{method: "POST", pattern: "/users", action: "orivil.actionController.Create",
+		controller: func() interface{} { return new(actionController) }})
+	inv, err := s.newInvoker("orivil.actionController.Create", reflect.TypeOf(&actionController{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.invokers["orivil.actionController.Create"] = inv
+	post := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/users", strings.NewReader("name="))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post(MediaJSON)
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("status = %d, body = %s: %v", w.Code, w.Body.String(), err)
+	}
+	if w.Code != 422 || problem.Status != 422 || len(problem.Errors) != 1 ||
+		problem.Errors[0].Field != "name" || problem.Errors[0].Rule != "required" || problem.Errors[0].Message == "" {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	w = post("text/html")
+	if w.Code != 422 || !strings.Contains(w.Body.String(), "<li>name: "+problem.Errors[0].Message+"</li>") {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+// newRespondApp returns the app which records the rendered data.
+func newRespondApp(rendered *[]interface{}) *App {
+	s := &Server{renderers: make(map[string]Renderer), formats: make(map[string]string)}
+	s.SetRenderer(MediaJSON, RendererFunc(func(app *App, data interface{}) error {
+		*rendered = append(*rendered, data)
+		return nil
+	}), "json")
+	return &App{Server: s, Request: httptest.NewRequest("GET", "/", nil), Response: httptest.NewRecorder()}
+}
+
+func TestRespond(t *testing.T) {
+	user := &actionUser{ID: 5}
+	responded := 0
+	response := ResponseFunc(func(app *App) error {
+		responded++
+		return nil
+	})
+	noError := reflect.Zero(errorType)
+	tests := []struct {
+		sig      actionSignature
+		out      []reflect.Value
+		rendered []interface{}
+	}{
+		{actionSignature{hasValue: true, hasError: true}, []reflect.Value{reflect.ValueOf(user), noError}, []interface{}{user}},
+		{actionSignature{hasValue: true, hasError: true}, []reflect.Value{reflect.ValueOf((*actionUser)(nil)), noError}, nil},
+		{actionSignature{hasValue: true}, []reflect.Value{reflect.ValueOf(actionUser{ID: 6})}, []interface{}{actionUser{ID: 6}}},
+		{actionSignature{hasValue: true}, []reflect.Value{reflect.ValueOf(response)}, nil},
+		{actionSignature{hasError: true}, []reflect.Value{noError}, nil},
+	}
+	for i, test := range tests {
+		var rendered []interface{}
+		newRespondApp(&rendered).respond(&test.sig, test.out)
+		if !reflect.DeepEqual(rendered, test.rendered) {
+			t.Errorf("test %d: rendered %v, want %v", i, rendered, test.rendered)
+		}
+	}
+	if responded != 1 {
+		t.Errorf("responded %d times, want 1", responded)
+	}
+}
+
+func TestRespondError(t *testing.T) {
+	for _, test := range []struct {
+		sig actionSignature
+		out []reflect.Value
+	}{
+		{actionSignature{hasError: true}, []reflect.Value{reflect.ValueOf(errors.New("failed"))}},
+		{actionSignature{hasValue: true}, []reflect.Value{reflect.ValueOf(ResponseFunc(func(app *App) error {
+			return errors.New("failed")
+		}))}},
+	} {
+		func() {
+			defer func() {
+				if v := recover(); v == nil || v.(error).Error() != "failed" {
+					t.Errorf("panic = %v, want failed", v)
+				}
+			}()
+			var rendered []interface{}
+			newRespondApp(&rendered).respond(&test.sig, test.out)
+		}()
+	}
+}
+
+func TestInvokerNotPrepared(t *testing.T) {
+	s := &Server{invokers: make(map[string]*invoker)}
+	defer func() {
+		if v := recover(); v == nil || !strings.Contains(v.(error).Error(), "was not prepared") {
+			t.Errorf("panic = %v", v)
+		}
+	}()
+	s.invoker("user.Controller.Show")
+}
@@ -5,6 +5,7 @@
 package orivil
 
 import (
+	"context"
 	"encoding/json"
 	"gopkg.in/orivil/router.v0"
 	"gopkg.in/orivil/service.v0"
@@ -19,7 +20,8 @@ import (
 	"time"
 )
 
-// FileStorage defines the callback which how to store uploaded files.
+// FileStorage defines the callback which how to store uploaded files, the
+// built-in storages can be adapted by NewFileStorage.
 type FileStorage func(srcFile multipart.File, name string) error
 
 // Check error
@@ -28,7 +30,7 @@ var ErrUploadFileTooLarge = errors.New("upload file too large")
 var ErrExitGorountine = errors.New("exit current gorountine")
 
 type App struct {
-	Response         http.ResponseWriter
+	Response         http.ResponseWriter // the ResponseWriter, see App.Writer
 	Request          *http.Request
 	Container        *service.Container // private container
 	VContainer       *view.Container
@@ -40,6 +42,12 @@ type App struct {
 	form             url.Values
 	data             map[string]interface{}
 	viewPages        []view.Page
+	rendered         bool
+	mediaType        string
+	writer           *responseWriter
+	ctx              context.Context
+	baseCtx          context.Context
+	cancel           context.CancelFunc
 	defers           []func()
 	memorySession    Session
 	permanentSession PSession
@@ -66,7 +74,8 @@ func GetMergedFile(a *App) (file []byte, err error) {
 	return a.Get(SvcServer).(*Server).VContainer.Combine(pages...)
 }
 
-// FormFiles reads and stores upload files.
+// FormFiles reads and stores upload files, the whole body will be parsed before
+// the store was called, use StreamFiles for large files.
 //
 // Usage:
 //
@@ -114,7 +123,8 @@ func (app *App) FormFiles(maxFile, maxMemory int64, store FileStorage) error {
 	// limit memory size
 	err := app.Request.ParseMultipartForm(maxMemory)
 	if err != nil {
-		if err.Error() == "multipart: Part Read: http: request body too large" {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
 			return ErrUploadFileTooLarge
 		} else {
 			return err
@@ -157,10 +167,18 @@ func (app *App) FormFiles(maxFile, maxMemory int64, store FileStorage) error {
 // the request body if request method is POST, PUT or PATCH;
 func (app *App) Form() url.Values {
 
+	if err := app.parseForm(); err != nil {
+		panic(err)
+	}
+	return app.form
+}
+
+// parseForm parses and caches the form values, see Form.
+func (app *App) parseForm() error {
 	if app.form == nil {
 		err := app.Request.ParseForm()
 		if err != nil {
-			panic(err)
+			return err
 		}
 		app.form = app.Request.PostForm
 
@@ -170,7 +188,7 @@ func (app *App) Form() url.Values {
 			app.form.Add(key, value)
 		}
 	}
-	return app.form
+	return nil
 }
 
 // Query reads the cache or parses values from two sources:
@@ -244,7 +262,7 @@ func (app *App) view(bundle, file string, debug bool) *App {
 	if filter, ok := app.Get(SvcI18nFilter).(I18nFilter); ok {
 		subDir = filter.ViewSubDir()
 	}
-	dir := filepath.Join(DirBundle, bundle, "view", subDir)
+	dir := filepath.Join(app.Server.viewDir(bundle), subDir)
 	if debug {
 		page = view.NewDebugPage(dir, file)
 	} else {
@@ -391,17 +409,9 @@ func (app *App) Defer(f func()) {
 }
 
 func (app *App) flash() {
-	// send view file
-	if app.viewPages != nil {
-		err := app.VContainer.Display(app.Response, app.data, app.viewPages...)
-		if err != nil {
-			panic(err)
-		}
-	} else {
-		// send api data
-		if len(app.data) > 0 {
-			app.JsonEncode(app.data)
-		}
+	// send view file or api data by the negotiated renderer
+	if !app.rendered && (app.viewPages != nil || len(app.data) > 0) {
+		app.Render(app.data)
 	}
 }
 
@@ -0,0 +1,167 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// AssetsKey is the view data key of the asset helper, e.g.
+//
+//	<link rel="stylesheet" href="{{.assets.URL "/css/app.css"}}">
+//	<script src="{{.assets.URL "/bundle-home/js/app.js"}}"></script>
+const AssetsKey = "assets"
+
+// the hex length of the content hash in the fingerprinted names
+const assetHashLen = 10
+
+// the Cache-Control header of the fingerprinted files
+const immutableCache = "public, max-age=31536000, immutable"
+
+// Assets keeps the content hashes of the static files, which are computed when
+// the server starts. The fingerprinted URL looks like "/css/app.3f2a9c1b0d.css",
+// its content never changes, so it is cached by browsers for one year.
+//
+// Fingerprinting is disabled in debug mode, so the changed files are served
+// without restarting the server.
+type Assets struct {
+	// the URL path => the content hash
+	hashes map[string]string
+	// the fingerprinted URL path => the URL path
+	originals map[string]string
+}
+
+func newAssets() *Assets {
+	return &Assets{
+		hashes:    make(map[string]string),
+		originals: make(map[string]string),
+	}
+}
+
+// Assets returns the static asset helper.
+func (s *Server) Assets() *Assets {
+
+	return s.assets
+}
+
+// AssetURL returns the fingerprinted URL of the static file, see Assets.URL.
+func (app *App) AssetURL(urlPath string) string {
+
+	return app.Server.assets.URL(urlPath)
+}
+
+// URL returns the fingerprinted URL of the static file, the URL path looks like
+// "/css/app.css" for the files of DirStaticFile, or "/bundle-home/css/app.css"
+// for the files of the bundle "public" directories. The URL path is returned
+// unchanged if the file is unknown, the query and the fragment are kept.
+func (a *Assets) URL(urlPath string) string {
+	p, suffix := urlPath, ""
+	if idx := strings.IndexAny(p, "?#"); idx >= 0 {
+		p, suffix = p[:idx], p[idx:]
+	}
+	if hash, ok := a.hashes[p]; ok {
+		return fingerprint(p, hash) + suffix
+	}
+	return urlPath
+}
+
+// Hash returns the content hash of the static file, returns empty string if
+// the file is unknown.
+func (a *Assets) Hash(urlPath string) string {
+
+	return a.hashes[urlPath]
+}
+
+// original returns the URL path of the fingerprinted URL path.
+func (a *Assets) original(urlPath string) (string, bool) {
+	p, ok := a.originals[urlPath]
+	return p, ok
+}
+
+// load computes the hashes of the files of the public roots, the keys of the
+// roots are the URL prefixes, see Server.publicRoots.
+func (a *Assets) load(roots map[string]fs.FS) error {
+	for prefix, fsys := range roots {
+		if err := a.loadFS(fsys, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Assets) loadFS(fsys fs.FS, prefix string) error {
+	if _, err := fs.Stat(fsys, "."); err != nil {
+		// the directory not exist
+		return nil
+	}
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && name != "." {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		// the precompressed files are served by their original files
+		if ext := path.Ext(name); ext == ".gz" || ext == ".br" {
+			return nil
+		}
+		hash, err := fileHash(fsys, name)
+		if err != nil {
+			return err
+		}
+		urlPath := prefix + name
+		a.hashes[urlPath] = hash
+		a.originals[fingerprint(urlPath, hash)] = urlPath
+		return nil
+	})
+}
+
+// fingerprint inserts the hash before the extension.
+func fingerprint(urlPath, hash string) string {
+	ext := path.Ext(urlPath)
+	return strings.TrimSuffix(urlPath, ext) + "." + hash + ext
+}
+
+func fileHash(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:assetHashLen], nil
+}
+
+// setCacheHeaders sets the caching headers of the static file, the fingerprinted
+// files are immutable, others are cached for "STATIC_MAX_AGE" seconds and
+// revalidated by "Last-Modified".
+func (s *Server) setCacheHeaders(w http.ResponseWriter, urlPath string, fingerprinted bool) {
+	h := w.Header()
+	switch {
+	case CfgApp.DEBUG:
+		h.Set("Cache-Control", "no-cache")
+	case fingerprinted:
+		h.Set("Cache-Control", immutableCache)
+		h.Set("ETag", `"`+s.assets.hashes[urlPath]+`"`)
+	default:
+		h.Set("Cache-Control", "public, max-age="+strconv.Itoa(CfgApp.STATIC_MAX_AGE))
+	}
+}
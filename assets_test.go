@@ -0,0 +1,152 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])[:assetHashLen]
+}
+
+func TestAssetsLoad(t *testing.T) {
+	a := newAssets()
+	err := a.load(map[string]fs.FS{
+		"/": fstest.MapFS{
+			"css/app.css":     {Data: []byte("body{}")},
+			"css/app.css.gz":  {Data: []byte("gzip")},
+			"LICENSE":         {Data: []byte("MIT")},
+			".git/config":     {Data: []byte("secret")},
+			"js/.env":         {Data: []byte("secret")},
+			"img/logo.svg.br": {Data: []byte("brotli")},
+		},
+		"/bundle-home/": fstest.MapFS{"js/app.js": {Data: []byte("run()")}},
+		"/bundle-none/": os.DirFS(filepath.Join(t.TempDir(), "missing")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/css/app.css":           contentHash("body{}"),
+		"/LICENSE":               contentHash("MIT"),
+		"/bundle-home/js/app.js": contentHash("run()"),
+	}
+	if !reflect.DeepEqual(a.hashes, want) {
+		t.Errorf("hashes = %v, want %v", a.hashes, want)
+	}
+	originals := map[string]string{
+		"/css/app." + want["/css/app.css"] + ".css":                     "/css/app.css",
+		"/LICENSE." + want["/LICENSE"]:                                  "/LICENSE",
+		"/bundle-home/js/app." + want["/bundle-home/js/app.js"] + ".js": "/bundle-home/js/app.js",
+	}
+	if !reflect.DeepEqual(a.originals, originals) {
+		t.Errorf("originals = %v, want %v", a.originals, originals)
+	}
+}
+
+func TestAssetsURL(t *testing.T) {
+	a := newAssets()
+	a.load(map[string]fs.FS{"/": fstest.MapFS{"css/app.css": {Data: []byte("body{}")}}})
+	hash := contentHash("body{}")
+	tests := []struct {
+		urlPath, want string
+	}{
+		{"/css/app.css", "/css/app." + hash + ".css"},
+		{"/css/app.css?v=1", "/css/app." + hash + ".css?v=1"},
+		{"/css/app.css#top", "/css/app." + hash + ".css#top"},
+		// the missing assets are returned unchanged
+		{"/css/missing.css", "/css/missing.css"},
+		{"/css/missing.css?v=1", "/css/missing.css?v=1"},
+		{"css/app.css", "css/app.css"},
+	}
+	for _, test := range tests {
+		if got := a.URL(test.urlPath); got != test.want {
+			t.Errorf("URL(%q) = %q, want %q", test.urlPath, got, test.want)
+		}
+	}
+	if p, ok := a.original("/css/app." + hash + ".css"); !ok || p != "/css/app.css" {
+		t.Errorf("original() = %q, %v", p, ok)
+	}
+	if _, ok := a.original("/css/app.0000000000.css"); ok {
+		t.Error("the stale fingerprint was resolved")
+	}
+	if a.Hash("/css/app.css") != hash || a.Hash("/css/missing.css") != "" {
+		t.Errorf("Hash() = %q", a.Hash("/css/app.css"))
+	}
+}
+
+func TestSetCacheHeaders(t *testing.T) {
+	debug, maxAge := CfgApp.DEBUG, CfgApp.STATIC_MAX_AGE
+	defer func() { CfgApp.DEBUG, CfgApp.STATIC_MAX_AGE = debug, maxAge }()
+	CfgApp.STATIC_MAX_AGE = 300
+
+	s := &Server{assets: newAssets()}
+	s.assets.hashes["/css/app.css"] = "3f2a9c1b0d"
+	tests := []struct {
+		debug         bool
+		fingerprinted bool
+		cache, etag   string
+	}{
+		{false, true, immutableCache, `"3f2a9c1b0d"`},
+		{false, false, "public, max-age=300", ""},
+		{true, true, "no-cache", ""},
+		{true, false, "no-cache", ""},
+	}
+	for _, test := range tests {
+		CfgApp.DEBUG = test.debug
+		w := httptest.NewRecorder()
+		s.setCacheHeaders(w, "/css/app.css", test.fingerprinted)
+		if w.Header().Get("Cache-Control") != test.cache || w.Header().Get("ETag") != test.etag {
+			t.Errorf("debug = %v, fingerprinted = %v: headers = %v", test.debug, test.fingerprinted, w.Header())
+		}
+	}
+}
+
+func TestServeFileCache(t *testing.T) {
+	s := newStaticServer(t)
+	debug := CfgApp.DEBUG
+	defer func() { CfgApp.DEBUG = debug }()
+	CfgApp.DEBUG = false
+	if err := s.assets.load(s.publicRoots()); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(urlPath string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", urlPath, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		s.serveFile(w, r, &staticLookup{server: s, path: urlPath})
+		return w
+	}
+
+	// the fingerprinted file is immutable
+	w := serve(s.assets.URL("/css/app.css"), nil)
+	if w.Code != 200 || w.Body.String() != "body{}" || w.Header().Get("Cache-Control") != immutableCache {
+		t.Errorf("fingerprinted: status = %d, headers = %v", w.Code, w.Header())
+	}
+
+	// others are revalidated by the modification time
+	w = serve("/css/app.css", nil)
+	modified := w.Header().Get("Last-Modified")
+	if w.Code != 200 || w.Header().Get("Cache-Control") == immutableCache || modified == "" {
+		t.Fatalf("original: status = %d, headers = %v", w.Code, w.Header())
+	}
+	w = serve("/css/app.css", http.Header{"If-Modified-Since": {modified}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation: status = %d, want 304", w.Code)
+	}
+}
@@ -0,0 +1,505 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the struct tags which Bind reads values from
+const (
+	TagParam  = "param"  // route params
+	TagQuery  = "query"  // URL query
+	TagForm   = "form"   // post form and route params
+	TagHeader = "header" // request header
+	TagValid  = "valid"  // validation rules, e.g. `valid:"required,min=1,max=20"`
+	TagLayout = "layout" // time layout, default is RFC3339 or "2006-01-02"
+)
+
+// max bytes of the JSON or XML request body which Bind decodes, the larger
+// bodies are rejected with 413
+var MaxBindBodySize int64 = 10 << 20
+
+var ErrBindTarget = errors.New("bind target must be a non-nil pointer to struct")
+
+// FieldError describes one field which could not be bound or validated.
+type FieldError struct {
+	Field   string `json:"field" xml:"field"`
+	Rule    string `json:"rule" xml:"rule"`
+	Message string `json:"message" xml:"message"`
+}
+
+func (e *FieldError) Error() string {
+
+	return e.Field + ": " + e.Message
+}
+
+// FieldErrors is the error list returned by Bind.
+type FieldErrors []*FieldError
+
+func (es FieldErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Map returns the first error message of each field, it is convenient for
+// views, e.g. {{.errors.name}}.
+func (es FieldErrors) Map() map[string]string {
+	m := make(map[string]string, len(es))
+	for _, e := range es {
+		if _, ok := m[e.Field]; !ok {
+			m[e.Field] = e.Message
+		}
+	}
+	return m
+}
+
+func (es FieldErrors) has(field string) bool {
+	for _, e := range es {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Bind fills the struct dst from the request, then validates it.
+//
+// JSON or XML request bodies are decoded into dst first, then the fields tagged
+// with "param", "query", "form" or "header" are set from the route params, the
+// URL query, the post form and the request header. The "valid" tag rules are
+// checked after all values were set.
+//
+// Usage:
+//
+//	type Search struct {
+//		ID    int       `param:"id" valid:"required"`
+//		Page  int       `query:"page" valid:"min=1"`
+//		Since time.Time `query:"since" layout:"2006-01-02"`
+//		Email string    `form:"email" valid:"required,email"`
+//		Token string    `header:"X-Token"`
+//	}
+//
+//	var s Search
+//	if err := app.Bind(&s); err != nil {
+//		if errs, ok := err.(orivil.FieldErrors); ok {
+//			app.With("errors", errs.Map())
+//		}
+//	}
+//
+// If the returned error is not a FieldErrors, the request or the target is bad,
+// the body or the form which can not be parsed returns the *HTTPError of 400,
+// the body larger than MaxBindBodySize returns the *HTTPError of 413. The rules are
+// compiled once for each struct type, the unknown or malformed rules are
+// returned as the error.
+func (app *App) Bind(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+	rules, err := compileRules(v.Elem().Type())
+	if err != nil {
+		return err
+	}
+	if err := app.bindBody(dst); err != nil {
+		return err
+	}
+	var errs FieldErrors
+	if err := app.bindFields(v.Elem(), &errs); err != nil {
+		return err
+	}
+	validateFields(v.Elem(), rules, &errs)
+	if len(errs) > 0 {
+		for _, e := range errs {
+			e.Message = app.FilterI18n(e.Message)
+		}
+		return errs
+	}
+	return nil
+}
+
+// bindBody decodes the JSON or XML body, the malformed body returns the
+// *HTTPError of 400, the body larger than MaxBindBodySize returns 413.
+func (app *App) bindBody(dst interface{}) error {
+	r := app.Request
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var decode func(io.Reader) error
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		decode = func(body io.Reader) error { return json.NewDecoder(body).Decode(dst) }
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		decode = func(body io.Reader) error { return xml.NewDecoder(body).Decode(dst) }
+	default:
+		return nil
+	}
+	if r.ContentLength > MaxBindBodySize {
+		return NewHTTPError(http.StatusRequestEntityTooLarge, "")
+	}
+	r.Body = http.MaxBytesReader(app.Response, r.Body, MaxBindBodySize)
+	err := decode(r.Body)
+	if err == nil || err == io.EOF {
+		return nil
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return NewHTTPError(http.StatusRequestEntityTooLarge, "", err)
+	}
+	return NewHTTPError(http.StatusBadRequest, "", err)
+}
+
+// bindFields sets the tagged fields, the values which can not be set are
+// added to errs, the returned error means the request could not be parsed.
+func (app *App) bindFields(v reflect.Value, errs *FieldErrors) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := app.bindFields(fv, errs); err != nil {
+				return err
+			}
+			continue
+		}
+		if !fv.CanSet() {
+			continue
+		}
+		name, values, ok, err := app.fieldValues(field)
+		if err != nil {
+			return err
+		}
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if err := setField(fv, values, field.Tag.Get(TagLayout)); err != nil {
+			*errs = append(*errs, &FieldError{Field: name, Rule: "type", Message: err.Error()})
+		}
+	}
+	return nil
+}
+
+// fieldValues reads the raw values of the field by its source tag.
+func (app *App) fieldValues(field reflect.StructField) (name string, values []string, ok bool, err error) {
+	if name = field.Tag.Get(TagParam); name != "" {
+		if value, exist := app.Params[name]; exist {
+			values = []string{value}
+		}
+		return name, values, true, nil
+	}
+	if name = field.Tag.Get(TagQuery); name != "" {
+		return name, app.Query()[name], true, nil
+	}
+	if name = field.Tag.Get(TagForm); name != "" {
+		if err = app.parseForm(); err != nil {
+			return name, nil, false, NewHTTPError(http.StatusBadRequest, "", err)
+		}
+		return name, app.form[name], true, nil
+	}
+	if name = field.Tag.Get(TagHeader); name != "" {
+		return name, app.Request.Header[textproto.CanonicalMIMEHeaderKey(name)], true, nil
+	}
+	return "", nil, false, nil
+}
+
+var typeTime = reflect.TypeOf(time.Time{})
+
+func setField(v reflect.Value, values []string, layout string) error {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValue(slice.Index(i), value, layout); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	return setValue(v, values[0], layout)
+}
+
+func setValue(v reflect.Value, value, layout string) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setValue(v.Elem(), value, layout)
+	}
+	if v.Type() == typeTime {
+		return setTime(v, value, layout)
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		if value == "on" {
+			value = "true"
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a positive integer", value)
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		// []byte
+		v.SetBytes([]byte(value))
+	default:
+		return fmt.Errorf("unsupported field type %v", v.Type())
+	}
+	return nil
+}
+
+func setTime(v reflect.Value, value, layout string) error {
+	layouts := []string{time.RFC3339, "2006-01-02"}
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	for _, l := range layouts {
+		if t, err := time.Parse(l, value); err == nil {
+			v.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not a valid time", value)
+}
+
+// rule is the compiled rule of the "valid" tag:
+//
+//	required       the value must not be zero
+//	min=N, max=N   the number value, or the length of string, slice and map
+//	len=N          the length of string, slice and map
+//	email          the string must be an email address
+//	in=a|b|c       the string must be one of the options
+//	match=EXPR     the string must match the regular expression, must be the last rule
+type rule struct {
+	name    string
+	arg     string
+	n       float64        // the number of min, max and len
+	options []string       // the options of in
+	re      *regexp.Regexp // the expression of match
+}
+
+// fieldRules is the compiled rules of the struct field.
+type fieldRules struct {
+	index []int // the field index, through the embedded structs
+	name  string
+	rules []rule
+}
+
+// the compiled rules of the struct types, the values are []fieldRules or error
+var structRules sync.Map
+
+// compileRules parses the "valid" tags of the struct type and its embedded
+// structs once, it returns the error if any rule is unknown or malformed.
+func compileRules(t reflect.Type) ([]fieldRules, error) {
+	if cached, ok := structRules.Load(t); ok {
+		if err, ok := cached.(error); ok {
+			return nil, err
+		}
+		return cached.([]fieldRules), nil
+	}
+	fields, err := parseFieldRules(t, nil)
+	if err != nil {
+		structRules.Store(t, err)
+		return nil, err
+	}
+	structRules.Store(t, fields)
+	return fields, nil
+}
+
+func parseFieldRules(t reflect.Type, index []int) (fields []fieldRules, err error) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		idx := append(append([]int{}, index...), i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			sub, err := parseFieldRules(field.Type, idx)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, sub...)
+			continue
+		}
+		tag := field.Tag.Get(TagValid)
+		if tag == "" {
+			continue
+		}
+		f := fieldRules{index: idx, name: fieldName(field)}
+		for _, str := range splitRules(tag) {
+			r, err := parseRule(str)
+			if err != nil {
+				return nil, fmt.Errorf("orivil: %v.%s: %v", t, field.Name, err)
+			}
+			f.rules = append(f.rules, r)
+		}
+		fields = append(fields, f)
+	}
+	return
+}
+
+func parseRule(str string) (r rule, err error) {
+	r.name = str
+	if idx := strings.Index(str, "="); idx > 0 {
+		r.name, r.arg = str[:idx], str[idx+1:]
+	}
+	switch r.name {
+	case "required", "email":
+	case "min", "max", "len":
+		if r.n, err = strconv.ParseFloat(r.arg, 64); err != nil {
+			return r, fmt.Errorf("bad validation rule %q", str)
+		}
+	case "in":
+		r.options = strings.Split(r.arg, "|")
+	case "match":
+		if r.re, err = regexp.Compile(r.arg); err != nil {
+			return r, fmt.Errorf("bad validation rule %q: %v", str, err)
+		}
+	default:
+		return r, fmt.Errorf("unknown validation rule %q", str)
+	}
+	return r, nil
+}
+
+// validateFields checks the compiled rules, the fields which could not be
+// bound are skipped.
+func validateFields(v reflect.Value, fields []fieldRules, errs *FieldErrors) {
+	for _, f := range fields {
+		if errs.has(f.name) {
+			continue
+		}
+		fv := v.FieldByIndex(f.index)
+		for i := range f.rules {
+			if msg := f.rules[i].check(fv); msg != "" {
+				*errs = append(*errs, &FieldError{Field: f.name, Rule: f.rules[i].name, Message: msg})
+				break
+			}
+		}
+	}
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{TagParam, TagQuery, TagForm, TagHeader, "json", "xml"} {
+		if name := strings.Split(field.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func splitRules(rules string) []string {
+	if idx := strings.Index(rules, "match="); idx >= 0 {
+		return append(splitRules(strings.TrimSuffix(rules[:idx], ",")), rules[idx:])
+	}
+	if rules == "" {
+		return nil
+	}
+	return strings.Split(rules, ",")
+}
+
+var emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+func (r *rule) check(v reflect.Value) (msg string) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if r.name == "required" {
+				return "is required"
+			}
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch r.name {
+	case "required":
+		if v.IsZero() {
+			return "is required"
+		}
+	case "min", "max", "len":
+		size, isLen := measure(v)
+		switch {
+		case r.name == "len" && size != r.n:
+			return fmt.Sprintf("length must be %s", r.arg)
+		case r.name == "min" && size < r.n && isLen:
+			return fmt.Sprintf("length must be at least %s", r.arg)
+		case r.name == "min" && size < r.n:
+			return fmt.Sprintf("must be at least %s", r.arg)
+		case r.name == "max" && size > r.n && isLen:
+			return fmt.Sprintf("length must be at most %s", r.arg)
+		case r.name == "max" && size > r.n:
+			return fmt.Sprintf("must be at most %s", r.arg)
+		}
+	case "email":
+		if v.Kind() == reflect.String && v.Len() > 0 && !emailRegexp.MatchString(v.String()) {
+			return "must be an email address"
+		}
+	case "in":
+		if v.Kind() == reflect.String && v.Len() > 0 {
+			for _, option := range r.options {
+				if option == v.String() {
+					return ""
+				}
+			}
+			return "must be one of " + strings.Join(r.options, ", ")
+		}
+	case "match":
+		if v.Kind() == reflect.String && v.Len() > 0 && !r.re.MatchString(v.String()) {
+			return "has an invalid format"
+		}
+	}
+	return ""
+}
+
+func measure(v reflect.Value) (size float64, isLen bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(len([]rune(v.String()))), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	}
+	return 0, false
+}
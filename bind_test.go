@@ -0,0 +1,137 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"errors"
+	"gopkg.in/orivil/service.v0"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newBindApp(r *http.Request) *App {
+
+	return &App{Request: r, Container: service.NewPrivateContainer(service.NewPublicContainer())}
+}
+
+type bindBase struct {
+	Code string `query:"code" valid:"match=^[a-z]+,[0-9]$"`
+}
+
+type bindUser struct {
+	bindBase
+	Name  string `form:"name" valid:"required,min=2"`
+	Age   int    `form:"age" valid:"min=18"`
+	Email string `form:"email" valid:"email"`
+	Role  string `query:"role" valid:"in=admin|user"`
+}
+
+func TestBind(t *testing.T) {
+	r := httptest.NewRequest("POST", "/?code=ab,1&role=guest", strings.NewReader("name=a&age=x&email=bad"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var u bindUser
+	err := newBindApp(r).Bind(&u)
+	errs, ok := err.(FieldErrors)
+	if !ok {
+		t.Fatalf("Bind() error = %v, want FieldErrors", err)
+	}
+	want := map[string]string{
+		"name":  "length must be at least 2",
+		"age":   `"x" is not an integer`,
+		"email": "must be an email address",
+		"role":  "must be one of admin, user",
+	}
+	if got := errs.Map(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Bind() errors = %v, want %v", got, want)
+	}
+	if u.Code != "ab,1" {
+		t.Errorf("Code = %q, want %q", u.Code, "ab,1")
+	}
+}
+
+func TestCompileRulesCache(t *testing.T) {
+	first, err := compileRules(reflect.TypeOf(bindUser{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := compileRules(reflect.TypeOf(bindUser{}))
+	if len(first) != 5 || &first[0] != &second[0] {
+		t.Errorf("the rules of the same type are not cached")
+	}
+	if re := first[0].rules[0].re; re == nil || re.String() != "^[a-z]+,[0-9]$" {
+		t.Errorf("match rule = %v, want the compiled expression", re)
+	}
+}
+
+func TestBindBadRules(t *testing.T) {
+	tests := []struct {
+		dst  interface{}
+		want string
+	}{
+		{&struct {
+			A string `valid:"required,unknown"`
+		}{}, `unknown validation rule "unknown"`},
+		{&struct {
+			A int `valid:"min=x"`
+		}{}, `bad validation rule "min=x"`},
+		{&struct {
+			A string `valid:"match=("`
+		}{}, `bad validation rule "match=("`},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		err := newBindApp(r).Bind(test.dst)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Bind(%T) error = %v, want %q", test.dst, err, test.want)
+		}
+	}
+}
+
+func TestBindFormError(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader("name=%zz"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var u bindUser
+	err := newBindApp(r).Bind(&u)
+	var he *HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusBadRequest {
+		t.Errorf("Bind() error = %v, want the HTTPError of 400", err)
+	}
+}
+
+func TestBindBody(t *testing.T) {
+	defer func(size int64) { MaxBindBodySize = size }(MaxBindBodySize)
+	MaxBindBodySize = 64
+	tests := []struct {
+		contentType string
+		body        string
+		unknownSize bool
+		code        int
+	}{
+		{"application/json", `{"Name":"john"}`, false, 0},
+		{"application/json", `{"Name":`, false, http.StatusBadRequest},
+		{"application/xml", `<user><Name>john</Name`, false, http.StatusBadRequest},
+		{"application/json", `{"Name":"` + strings.Repeat("a", 100) + `"}`, false, http.StatusRequestEntityTooLarge},
+		{"application/json", `{"Name":"` + strings.Repeat("a", 100) + `"}`, true, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+		r.Header.Set("Content-Type", test.contentType)
+		if test.unknownSize {
+			r.ContentLength = -1
+		}
+		var u struct{ Name string }
+		err := newBindApp(r).Bind(&u)
+		var he *HTTPError
+		switch {
+		case test.code == 0 && (err != nil || u.Name != "john"):
+			t.Errorf("Bind(%s) = %v, name %q", test.body, err, u.Name)
+		case test.code != 0 && (!errors.As(err, &he) || he.Code != test.code):
+			t.Errorf("Bind(%.20s) error = %v, want the HTTPError of %d", test.body, err, test.code)
+		}
+	}
+}
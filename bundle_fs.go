@@ -0,0 +1,177 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"gopkg.in/yaml.v2"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// the bundle directories which can be supplied by BundleFS
+const (
+	BundleView   = "view"
+	BundlePublic = "public"
+	BundleConfig = "config"
+)
+
+// BundleFS is the optional interface of the bundle registers, the file system
+// contains the "view", "public" and "config" directories of the bundle, so the
+// bundle can be shipped in a single binary. In debug mode, the directories on
+// disk("DirBundle/<bundle>/view"...) override the embedded directories. The
+// embedded views are extracted to a temporary directory when the server starts,
+// because the view container reads the files from disk.
+//
+// Usage:
+//
+//	//go:embed view public config
+//	var files embed.FS
+//
+//	func (*Register) FS() fs.FS {
+//		return files
+//	}
+type BundleFS interface {
+	FS() fs.FS
+}
+
+// bundleName returns the bundle name of the register, which is the package
+// directory name.
+func bundleName(r Register) string {
+
+	return filepath.Base(reflect.TypeOf(r).Elem().PkgPath())
+}
+
+// bundleFS returns the embedded directory of the bundle, returns nil if the
+// bundle has no file system, the file system has no such directory, or the
+// directory should be read from disk.
+func (s *Server) bundleFS(bundle, dir string) fs.FS {
+	b := s.registerFS(bundle)
+	if b == nil || CfgApp.DEBUG && isDir(filepath.Join(DirBundle, bundle, dir)) {
+		return nil
+	}
+	sub, err := fs.Sub(b.FS(), dir)
+	if err != nil {
+		return nil
+	}
+	// fs.Sub does not check the directory
+	if _, err := fs.Stat(sub, "."); err != nil {
+		return nil
+	}
+	return sub
+}
+
+// registerFS returns the register of the bundle if it implements BundleFS.
+func (s *Server) registerFS(bundle string) BundleFS {
+	for _, r := range s.registers {
+		if b, ok := r.(BundleFS); ok && bundleName(r) == bundle {
+			return b
+		}
+	}
+	return nil
+}
+
+func isDir(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+// viewDir returns the view directory of the bundle, the embedded views are
+// extracted to a temporary directory when the server starts, because the view
+// container reads the files from disk. The directory is removed when the
+// server closed or could not start, but it is left in os.TempDir() if the
+// process was killed.
+func (s *Server) viewDir(bundle string) string {
+	if dir, ok := s.viewDirs[bundle]; ok {
+		return dir
+	}
+	return filepath.Join(DirBundle, bundle, BundleView)
+}
+
+// extractViews copies the embedded views of the bundles to the temporary
+// directory, it is removed when the server closed.
+func (s *Server) extractViews() error {
+	for _, r := range s.registers {
+		bundle := bundleName(r)
+		fsys := s.bundleFS(bundle, BundleView)
+		if fsys == nil {
+			continue
+		}
+		if s.viewTmp == "" {
+			tmp, err := os.MkdirTemp("", "orivil-view-")
+			if err != nil {
+				return err
+			}
+			s.viewTmp = tmp
+		}
+		dir := filepath.Join(s.viewTmp, bundle)
+		err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(dir, filepath.FromSlash(name))
+			if d.IsDir() {
+				return os.MkdirAll(dst, 0755)
+			}
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(dst, data, 0644)
+		})
+		if err != nil {
+			return err
+		}
+		s.viewDirs[bundle] = dir
+	}
+	return nil
+}
+
+// removeViews removes the extracted views.
+func (s *Server) removeViews() {
+	if s.viewTmp == "" {
+		return
+	}
+	os.RemoveAll(s.viewTmp)
+	s.viewTmp = ""
+	s.viewDirs = make(map[string]string)
+}
+
+// ReadConfig reads the config file of the bundle to the struct, the file is
+// read from the "config" directory of the bundle file system if the bundle
+// implements BundleFS, or from "DirBundle/<bundle>/config" if the directory
+// overrides it in debug mode, otherwise from DirConfig.
+func (s *Server) ReadConfig(bundle, file string, v interface{}) error {
+	fsys := s.bundleFS(bundle, BundleConfig)
+	if fsys == nil {
+		dir := filepath.Join(DirBundle, bundle, BundleConfig)
+		if s.registerFS(bundle) == nil || !CfgApp.DEBUG || !isDir(dir) {
+			return Cfg.ReadStruct(file, v)
+		}
+		fsys = os.DirFS(dir)
+	}
+	data, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, v)
+}
+
+// publicRoots returns the public file systems of the URL prefixes, "/" is the
+// DirStaticFile and "/bundle-<bundle>/" is the "public" directory of the
+// registered bundle.
+func (s *Server) publicRoots() map[string]fs.FS {
+	roots := map[string]fs.FS{"/": os.DirFS(DirStaticFile)}
+	for _, r := range s.registers {
+		bundle := bundleName(r)
+		fsys := s.bundleFS(bundle, BundlePublic)
+		if fsys == nil {
+			fsys = os.DirFS(filepath.Join(DirBundle, bundle, BundlePublic))
+		}
+		roots[bundlePrefix+bundle+"/"] = fsys
+	}
+	return roots
+}
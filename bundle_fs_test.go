@@ -0,0 +1,103 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+type fsRegister struct {
+	BaseRegister
+	fsys fs.FS
+}
+
+func (r *fsRegister) FS() fs.FS {
+
+	return r.fsys
+}
+
+func TestExtractViews(t *testing.T) {
+	r := &fsRegister{fsys: fstest.MapFS{
+		"view/index.tmpl":       {Data: []byte("index")},
+		"view/user/detail.tmpl": {Data: []byte("detail")},
+	}}
+	s := &Server{registers: []Register{r}, viewDirs: make(map[string]string)}
+	if err := s.extractViews(); err != nil {
+		t.Fatal(err)
+	}
+	tmp := s.viewTmp
+	dir := s.viewDir(bundleName(r))
+	if data, err := os.ReadFile(filepath.Join(dir, "user", "detail.tmpl")); err != nil || string(data) != "detail" {
+		t.Fatalf("extracted view = %q, %v", data, err)
+	}
+	s.close()
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("the temporary directory %s was not removed: %v", tmp, err)
+	}
+	if s.viewDir(bundleName(r)) == dir {
+		t.Errorf("the removed view directory is still used")
+	}
+}
+
+type bundleConfig struct {
+	Name string `yaml:"name"`
+}
+
+// newConfigServer returns the server of the bundle which embeds the config, and
+// DirBundle has the config of the bundle on disk.
+func newConfigServer(t *testing.T, fsys fs.FS) (*Server, string) {
+	dir := t.TempDir()
+	old := DirBundle
+	DirBundle = dir
+	t.Cleanup(func() { DirBundle = old })
+	r := &fsRegister{fsys: fsys}
+	bundle := bundleName(r)
+	os.MkdirAll(filepath.Join(dir, bundle, BundleConfig), 0755)
+	os.WriteFile(filepath.Join(dir, bundle, BundleConfig, "app.yml"), []byte("name: disk"), 0644)
+	return &Server{registers: []Register{r}}, bundle
+}
+
+func TestReadConfig(t *testing.T) {
+	debug := CfgApp.DEBUG
+	defer func() { CfgApp.DEBUG = debug }()
+	s, bundle := newConfigServer(t, fstest.MapFS{"config/app.yml": {Data: []byte("name: embedded")}})
+	for _, test := range []struct {
+		debug bool
+		want  string
+	}{
+		{true, "disk"},
+		{false, "embedded"},
+	} {
+		CfgApp.DEBUG = test.debug
+		var v bundleConfig
+		if err := s.ReadConfig(bundle, "app.yml", &v); err != nil || v.Name != test.want {
+			t.Errorf("debug = %v: ReadConfig() = %q, %v, want %q", test.debug, v.Name, err, test.want)
+		}
+	}
+}
+
+func TestReadConfigNotEmbedded(t *testing.T) {
+	debug := CfgApp.DEBUG
+	defer func() { CfgApp.DEBUG = debug }()
+	CfgApp.DEBUG = false
+
+	// the embedded file system has no config directory, the config is read
+	// from DirConfig
+	s, bundle := newConfigServer(t, fstest.MapFS{"view/index.tmpl": {Data: []byte("index")}})
+	if fsys := s.bundleFS(bundle, BundleConfig); fsys != nil {
+		t.Errorf("bundleFS() = %v, want nil", fsys)
+	}
+	if fsys := s.bundleFS(bundle, BundleView); fsys == nil {
+		t.Error("bundleFS(view) = nil")
+	}
+	var v bundleConfig
+	if err := s.ReadConfig(bundle, "missing.yml", &v); err != nil || v.Name != "" {
+		t.Errorf("ReadConfig() = %q, %v", v.Name, err)
+	}
+}
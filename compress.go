@@ -0,0 +1,368 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// content encodings, brotli has no built-in encoder, the ".br" files are served
+// if "PRECOMPRESSED" is enabled, see SetEncoder for compressing the responses
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingBrotli  = "br"
+)
+
+// Encoder creates the compressing writer of a content encoding.
+type Encoder func(w io.Writer) (io.WriteCloser, error)
+
+// the content types which are compressed by default, the types end with "/"
+// are prefixes, the types start with "+" are suffixes.
+var defaultCompressTypes = []string{
+	"text/",
+	"+json",
+	"+xml",
+	MediaJSON,
+	MediaXML,
+	MediaYAML,
+	"application/javascript",
+	"application/x-javascript",
+	"application/wasm",
+	"image/svg+xml",
+	"image/x-icon",
+	"font/ttf",
+	"font/otf",
+	"application/vnd.ms-fontobject",
+}
+
+// the precompressed file extensions, in the server preference
+var precompressedExts = []struct{ encoding, ext string }{
+	{EncodingBrotli, ".br"},
+	{EncodingGzip, ".gz"},
+}
+
+// SetEncoder registers the encoder of the content encoding, the encodings are
+// preferred in the registration order when the client accepts them equally.
+// Only "gzip" and "deflate" are registered by default, the responses are not
+// compressed by brotli unless its encoder is registered by a third party
+// package.
+//
+// Usage:
+//
+//	s.SetEncoder(orivil.EncodingBrotli, func(w io.Writer) (io.WriteCloser, error) {
+//		return brotli.NewWriterLevel(w, brotli.DefaultCompression), nil
+//	})
+func (s *Server) SetEncoder(encoding string, e Encoder) {
+	if _, ok := s.encoders[encoding]; !ok {
+		s.encodings = append(s.encodings, encoding)
+	}
+	s.encoders[encoding] = e
+}
+
+// SetCompressTypes replaces the content types which will be compressed, the
+// types end with "/" match the prefixes, e.g. "text/", the types start with "+"
+// match the suffixes, e.g. "+json".
+func (s *Server) SetCompressTypes(types ...string) {
+
+	s.compressTypes = types
+}
+
+func (s *Server) setDefaultEncoders() {
+	s.SetEncoder(EncodingGzip, func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	})
+	s.SetEncoder(EncodingDeflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.DefaultCompression)
+	})
+	s.compressTypes = defaultCompressTypes
+}
+
+// compressible checks whether or not the content type is in the compress types.
+func (s *Server) compressible(contentType string) bool {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = t
+	}
+	contentType = strings.ToLower(contentType)
+	for _, t := range s.compressTypes {
+		switch {
+		case strings.HasSuffix(t, "/"):
+			if strings.HasPrefix(contentType, t) {
+				return true
+			}
+		case strings.HasPrefix(t, "+"):
+			if strings.HasSuffix(contentType, t) {
+				return true
+			}
+		case t == contentType:
+			return true
+		}
+	}
+	return false
+}
+
+// compress wraps the writer for compressing the response if "COMPRESS" is
+// enabled, the returned writer must be closed after the response finished.
+func (s *Server) compress(w http.ResponseWriter, r *http.Request) *compressWriter {
+	if !CfgApp.COMPRESS {
+		return nil
+	}
+	return &compressWriter{
+		ResponseWriter: w,
+		server:         s,
+		encoding:       Negotiate(r.Header.Get("Accept-Encoding"), s.encodings, ""),
+		minSize:        CfgApp.COMPRESS_MIN_SIZE,
+		head:           r.Method == "HEAD",
+	}
+}
+
+// compressWriter buffers the beginning of the body until "COMPRESS_MIN_SIZE"
+// bytes were written, then decides whether or not to compress the response by
+// the status, the headers and the content type. The "Vary" header is added for
+// every compressible response, even if the client accepts no encoding.
+type compressWriter struct {
+	http.ResponseWriter
+	server   *Server
+	encoding string // the negotiated encoding, empty means identity
+	minSize  int
+	head     bool
+	code     int
+	buf      []byte
+	decided  bool
+	enc      io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.code != 0 || cw.decided {
+		return
+	}
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		// informational responses are sent directly
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.code = code
+	if !bodyAllowed(code) || cw.head || cw.ResponseWriter.Header().Get("Content-Encoding") != "" {
+		cw.decide(false)
+		return
+	}
+	if cl := cw.ResponseWriter.Header().Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < cw.minSize {
+			cw.decide(false)
+		}
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.code == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+		if err := cw.start(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// start decides and sends the buffered body.
+func (cw *compressWriter) start() error {
+	cw.decide(true)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// decide sends the header, the response is compressed only if large is true
+// and the response is compressible.
+func (cw *compressWriter) decide(large bool) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+	h := cw.ResponseWriter.Header()
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+	eligible := bodyAllowed(cw.code) && cw.code != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Range") == ""
+	if eligible {
+		contentType := h.Get("Content-Type")
+		if contentType == "" && len(cw.buf) > 0 {
+			contentType = http.DetectContentType(cw.buf)
+			h.Set("Content-Type", contentType)
+		}
+		eligible = cw.server.compressible(contentType)
+	}
+	if eligible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if eligible && large && cw.encoding != "" && !cw.head {
+		enc, err := cw.server.encoders[cw.encoding](cw.ResponseWriter)
+		if err == nil {
+			cw.enc = enc
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			// the compressed body is another representation
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.code)
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.decided && cw.code != 0 {
+		// the streaming response is compressed without waiting the min size
+		cw.start()
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends the buffered body and closes the encoder.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.code == 0 {
+			// nothing was written
+			return nil
+		}
+		cw.decide(false)
+		if len(cw.buf) > 0 {
+			cw.ResponseWriter.Write(cw.buf)
+			cw.buf = nil
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (cw *compressWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := cw.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+
+	return cw.ResponseWriter
+}
+
+func bodyAllowed(code int) bool {
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// servePrecompressed serves the ".br" or ".gz" sibling of the file if it exists
+// and the client accepts the encoding, returns false if no sibling was served.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) bool {
+	if r.Header.Get("Accept-Encoding") == "" {
+		return false
+	}
+	if info, err := fs.Stat(fsys, name); err != nil || info.IsDir() {
+		return false
+	}
+	// only the existing siblings are offered, so "br, gzip" gets the ".gz"
+	// file if there is no ".br" file
+	var offers []string
+	for _, p := range precompressedExts {
+		if info, err := fs.Stat(fsys, name+p.ext); err == nil && info.Mode().IsRegular() {
+			offers = append(offers, p.encoding)
+		}
+	}
+	encoding := Negotiate(r.Header.Get("Accept-Encoding"), offers, "")
+	if encoding == "" {
+		return false
+	}
+	for _, p := range precompressedExts {
+		if p.encoding != encoding {
+			continue
+		}
+		f, err := fsys.Open(name + p.ext)
+		if err != nil {
+			return false
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			return false
+		}
+		content, ok := f.(io.ReadSeeker)
+		if !ok {
+			return false
+		}
+		h := w.Header()
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			// sniff the original file, ServeContent would sniff the compressed one
+			contentType = sniffFile(fsys, name)
+		}
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Encoding", encoding)
+		h.Add("Vary", "Accept-Encoding")
+		// the compressed file is another representation
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		http.ServeContent(w, r, name, info.ModTime(), content)
+		return true
+	}
+	return false
+}
+
+func sniffFile(fsys fs.FS, name string) string {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	io.CopyN(&buf, f, 512)
+	return http.DetectContentType(buf.Bytes())
+}
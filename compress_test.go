@@ -0,0 +1,195 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func newCompressServer() *Server {
+	s := &Server{encoders: make(map[string]Encoder)}
+	s.setDefaultEncoders()
+	return s
+}
+
+// compressResponse writes the response by the compressing writer whose minimum
+// size is 100 bytes.
+func compressResponse(s *Server, method, acceptEncoding string, handler func(w http.ResponseWriter)) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(method, "/", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	cw := &compressWriter{
+		ResponseWriter: rec,
+		server:         s,
+		encoding:       Negotiate(acceptEncoding, s.encodings, ""),
+		minSize:        100,
+		head:           method == "HEAD",
+	}
+	handler(cw)
+	cw.Close()
+	return rec
+}
+
+var compressBody = strings.Repeat("compressible text ", 20)
+
+func TestCompressWriter(t *testing.T) {
+	s := newCompressServer()
+	write := func(header map[string]string, code int, body string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			for k, v := range header {
+				w.Header().Set(k, v)
+			}
+			if code != 0 {
+				w.WriteHeader(code)
+			}
+			io.WriteString(w, body)
+		}
+	}
+	text := map[string]string{"Content-Type": "text/plain"}
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		handler        func(w http.ResponseWriter)
+		encoding       string
+		vary           bool
+	}{
+		{"large", "GET", "gzip", write(text, 0, compressBody), "gzip", true},
+		{"deflate", "GET", "deflate, gzip;q=0.5", write(text, 0, compressBody), "deflate", true},
+		{"brotli is not built in", "GET", "br, gzip;q=0.5", write(text, 0, compressBody), "gzip", true},
+		{"small", "GET", "gzip", write(text, 0, "small"), "", true},
+		{"small content length", "GET", "gzip", write(map[string]string{"Content-Type": "text/plain", "Content-Length": "5"}, 0, "small"), "", true},
+		{"identity", "GET", "", write(text, 0, compressBody), "", true},
+		{"sniffed", "GET", "gzip", write(nil, 0, "<html>"+compressBody), "gzip", true},
+		{"image", "GET", "gzip", write(map[string]string{"Content-Type": "image/png"}, 0, compressBody), "", false},
+		{"encoded", "GET", "gzip", write(map[string]string{"Content-Type": "text/plain", "Content-Encoding": "br"}, 0, compressBody), "br", false},
+		{"partial", "GET", "gzip", write(map[string]string{"Content-Type": "text/plain", "Content-Range": "bytes 0-359/1000"}, 206, compressBody), "", false},
+		{"content range", "GET", "gzip", write(map[string]string{"Content-Type": "text/plain", "Content-Range": "bytes */1000"}, 416, compressBody), "", false},
+		{"no content", "GET", "gzip", write(text, 204, ""), "", false},
+		{"head", "HEAD", "gzip", write(text, 0, compressBody), "", true},
+	}
+	for _, test := range tests {
+		w := compressResponse(s, test.method, test.acceptEncoding, test.handler)
+		if got := w.Header().Get("Content-Encoding"); got != test.encoding {
+			t.Errorf("%s: Content-Encoding = %q, want %q", test.name, got, test.encoding)
+		}
+		if vary := w.Header().Get("Vary") == "Accept-Encoding"; vary != test.vary {
+			t.Errorf("%s: Vary = %q", test.name, w.Header().Get("Vary"))
+		}
+		if test.encoding != "gzip" && test.encoding != "deflate" && !strings.Contains(compressBody+"small", w.Body.String()) {
+			t.Errorf("%s: body = %q", test.name, w.Body.String())
+		}
+	}
+}
+
+func TestCompressWriterGzip(t *testing.T) {
+	w := compressResponse(newCompressServer(), "GET", "gzip", func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "360")
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", `"v1"`)
+		for i := 0; i < 20; i++ {
+			// the writes smaller than the minimum size are buffered
+			io.WriteString(w, "compressible text ")
+		}
+	})
+	h := w.Header()
+	if h.Get("Content-Length") != "" || h.Get("Accept-Ranges") != "" || h.Get("ETag") != `W/"v1"` {
+		t.Errorf("headers = %v", h)
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil || string(body) != compressBody {
+		t.Errorf("body = %q, %v", body, err)
+	}
+
+	w = compressResponse(newCompressServer(), "GET", "gzip", func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `W/"v1"`)
+		io.WriteString(w, compressBody)
+	})
+	if h := w.Header().Get("ETag"); h != `W/"v1"` {
+		t.Errorf("weak ETag = %q", h)
+	}
+}
+
+func TestCompressDisabled(t *testing.T) {
+	compress := CfgApp.COMPRESS
+	defer func() { CfgApp.COMPRESS = compress }()
+	CfgApp.COMPRESS = false
+	if cw := newCompressServer().compress(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); cw != nil {
+		t.Error("the writer was wrapped")
+	}
+}
+
+func TestServePrecompressed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.css":    {Data: []byte("body{}")},
+		"app.css.br": {Data: []byte("brotli")},
+		"app.css.gz": {Data: []byte("gzip")},
+		"app.js":     {Data: []byte("run()")},
+		"app.js.gz":  {Data: []byte("gzip js")},
+		"data":       {Data: []byte("<html>")},
+		"data.gz":    {Data: []byte("gzip data")},
+		"lib.js.gz":  {Data: []byte("no original")},
+	}
+	tests := []struct {
+		name, acceptEncoding string
+		served               bool
+		encoding, body       string
+		contentType          string
+	}{
+		{"app.css", "gzip, br", true, "br", "brotli", "text/css; charset=utf-8"},
+		{"app.css", "gzip", true, "gzip", "gzip", "text/css; charset=utf-8"},
+		{"app.css", "br;q=0.5, gzip", true, "gzip", "gzip", "text/css; charset=utf-8"},
+		{"app.js", "br, gzip", true, "gzip", "gzip js", "text/javascript; charset=utf-8"},
+		{"app.js", "br", false, "", "", ""},
+		{"app.css", "", false, "", "", ""},
+		{"app.css", "identity", false, "", "", ""},
+		{"data", "gzip", true, "gzip", "gzip data", "text/html; charset=utf-8"},
+		{"lib.js", "gzip", false, "", "", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/"+test.name, nil)
+		r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		served := servePrecompressed(w, r, fsys, test.name)
+		if served != test.served {
+			t.Errorf("%s %q: served = %v", test.name, test.acceptEncoding, served)
+			continue
+		}
+		if !served {
+			continue
+		}
+		h := w.Header()
+		if h.Get("Content-Encoding") != test.encoding || w.Body.String() != test.body ||
+			h.Get("Content-Type") != test.contentType || h.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s %q: headers = %v, body = %q", test.name, test.acceptEncoding, h, w.Body.String())
+		}
+	}
+}
+
+func TestServePrecompressedETag(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.css":    {Data: []byte("body{}")},
+		"app.css.gz": {Data: []byte("gzip")},
+	}
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `"v1"`)
+	r := httptest.NewRequest("GET", "/app.css", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	if !servePrecompressed(w, r, fsys, "app.css") || w.Header().Get("ETag") != `W/"v1"` {
+		t.Errorf("ETag = %q", w.Header().Get("ETag"))
+	}
+}
@@ -12,12 +12,13 @@ import (
 	"os/exec"
 	"path/filepath"
 	"gopkg.in/orivil/log.v0"
-	"fmt"
 )
 
 // server config
 var CfgApp = &struct {
 	DEBUG                     bool
+	DEBUG_TOKEN               string // allows non-loopback clients to use the debug tools, see DebugTokenHeader
+	BASE_URL                  string // the scheme and host of the absolute URLs, e.g. "https://example.com"
 	KEY                       string
 	VIEW_FILE_EXT             string
 	MEMORY_SESSION_KEY        string
@@ -28,6 +29,16 @@ var CfgApp = &struct {
 	PERMANENT_GC_CHECK_NUM    int
 	READ_TIMEOUT              int // second
 	WRITE_TIMEOUT             int // second
+	TIMEOUT                   int // second, the action timeout, default is WRITE_TIMEOUT
+	ACCESS_LOG                string // access log file, relative to DirBase, empty means no access log
+	ACCESS_LOG_FORMAT         string // "common", "combined" or "json"
+	ACCESS_LOG_MAX_SIZE       int // MB, rotate the access log file when exceeded
+	ACCESS_LOG_BACKUPS        int // number of the rotated access log files
+	COMPRESS                  bool // compress the responses by gzip or deflate by the "Accept-Encoding" header
+	COMPRESS_MIN_SIZE         int // byte, the smaller responses are not compressed
+	PRECOMPRESSED             bool // serve the ".br" or ".gz" siblings of the static files
+	STATIC_MAX_AGE            int // second, the cache age of the static files which are not fingerprinted
+	STATIC_DIR_LISTING        bool // list the static directories which have no "index.html"
 }{
 	// default config
 	DEBUG:                     true,
@@ -41,6 +52,12 @@ var CfgApp = &struct {
 	PERMANENT_GC_CHECK_NUM:    3,
 	READ_TIMEOUT:              30,
 	WRITE_TIMEOUT:             30,
+	ACCESS_LOG_FORMAT:         "combined",
+	ACCESS_LOG_MAX_SIZE:       100,
+	ACCESS_LOG_BACKUPS:        7,
+	COMPRESS:                  true,
+	COMPRESS_MIN_SIZE:         1024,
+	STATIC_MAX_AGE:            300,
 }
 
 // dirs
@@ -75,6 +92,10 @@ func init() {
 	)
 }
 
+// getBaseDir returns the directory which contains the "bundle" directory, it
+// looks up the current directory and the executable file directory, returns
+// the current directory if neither of them contains the "bundle" directory,
+// because the bundles may be embedded in the binary, see BundleFS.
 func getBaseDir() string {
 	cDir, err := os.Getwd()
 	if err != nil {
@@ -83,24 +104,14 @@ func getBaseDir() string {
 
 	if helper.IsExist(filepath.Join(cDir, "bundle")) {
 		return cDir
-	} else {
-		file, err := exec.LookPath(os.Args[0])
-		if err != nil {
-			panic(err)
-		}
-		path, err := filepath.Abs(file)
-		if err != nil {
-			panic(err)
-		}
-		eDir := filepath.Dir(path)
-
-		if helper.IsExist(filepath.Join(eDir, "bundle")) {
-			return eDir
-		} else {
-			fmt.Printf("Directory 'bundle' not exist in current directory: [%s] "+
-			"or executable file directory: [%s]", cDir, eDir)
-			os.Exit(1)
+	}
+	if file, err := exec.LookPath(os.Args[0]); err == nil {
+		if path, err := filepath.Abs(file); err == nil {
+			eDir := filepath.Dir(path)
+			if helper.IsExist(filepath.Join(eDir, "bundle")) {
+				return eDir
+			}
 		}
 	}
-	return ""
+	return cDir
 }
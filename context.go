@@ -0,0 +1,153 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// RequestIDHeader is the header for reading and sending the request ID.
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userKey
+	appKey
+)
+
+// SetRouteTimeout sets the deadline duration of the action context, the
+// action looks like "bundle.Controller.Action". Zero duration means no deadline.
+// When the deadline exceeded, the context is canceled and the 503 response
+// is sent if nothing was written.
+func (s *Server) SetRouteTimeout(action string, d time.Duration) {
+
+	s.routeTimeouts[action] = d
+}
+
+// RouteTimeout returns the deadline duration of the action context, it is
+// the "TIMEOUT" config value if the action has no timeout, or the
+// "WRITE_TIMEOUT" config value if "TIMEOUT" is not set.
+func (s *Server) RouteTimeout(action string) time.Duration {
+	if d, ok := s.routeTimeouts[action]; ok {
+		return d
+	}
+	if CfgApp.TIMEOUT > 0 {
+		return time.Second * time.Duration(CfgApp.TIMEOUT)
+	}
+	return time.Second * time.Duration(CfgApp.WRITE_TIMEOUT)
+}
+
+// initContext derives the app context from the request context, with the
+// route deadline, the request ID and the app. The context is canceled when
+// the request finished.
+func (app *App) initContext() {
+	id := app.Request.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	app.writer.setHeader(RequestIDHeader, id)
+
+	w, r, action, start := app.writer, app.Request, app.Action, app.Start
+	tc := newTimeoutContext(r.Context(), func() {
+		app.Server.onTimeout(w, r, action, id, start)
+	})
+	app.baseCtx = tc
+	app.cancel = tc.cancel
+	app.SetTimeout(app.Server.RouteTimeout(app.Action))
+
+	ctx := context.WithValue(tc, requestIDKey, id)
+	ctx = context.WithValue(ctx, appKey, app)
+	app.SetContext(ctx)
+}
+
+// Context returns the request scoped context, it will be canceled if the client
+// disconnected, the route deadline exceeded or the request finished. Pass it
+// to DB calls and outbound HTTP calls so they abort together with the request.
+func (app *App) Context() context.Context {
+	if app.ctx == nil {
+		return app.Request.Context()
+	}
+	return app.ctx
+}
+
+// SetContext replaces the app context, middleware and controllers can derive
+// child contexts from App.Context and set them back, e.g.
+//
+//	ctx, cancel := context.WithTimeout(app.Context(), time.Second)
+//	app.Defer(cancel)
+//	app.SetContext(ctx)
+func (app *App) SetContext(ctx context.Context) {
+	app.ctx = ctx
+	app.Request = app.Request.WithContext(ctx)
+}
+
+// WithValue adds the value to the app context.
+func (app *App) WithValue(key, value interface{}) {
+
+	app.SetContext(context.WithValue(app.Context(), key, value))
+}
+
+// RequestID returns the request ID which was read from the "X-Request-ID"
+// header or generated.
+func (app *App) RequestID() string {
+
+	return RequestIDFromContext(app.Context())
+}
+
+// SetUser adds the authenticated user to the app context, it is usually called
+// by the authentication middleware.
+func (app *App) SetUser(user interface{}) {
+
+	app.SetContext(context.WithValue(app.Context(), userKey, user))
+}
+
+// User returns the user set by SetUser.
+func (app *App) User() interface{} {
+
+	return UserFromContext(app.Context())
+}
+
+// RequestIDFromContext returns the request ID of the context.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// UserFromContext returns the user of the context.
+func UserFromContext(ctx context.Context) interface{} {
+
+	return ctx.Value(userKey)
+}
+
+// AppFromContext returns the app of the context.
+func AppFromContext(ctx context.Context) *App {
+	app, _ := ctx.Value(appKey).(*App)
+	return app
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
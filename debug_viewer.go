@@ -0,0 +1,375 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"go/scanner"
+	"go/token"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the lines shown around the target line by default, and the max lines
+const (
+	debugContextLines    = 15
+	debugMaxContextLines = 200
+)
+
+// DebugTokenHeader is the request header for sending the debug token. Browsers
+// post the token by the form of the debug page once instead, the server sets the
+// debug cookie, so the token never appears in URLs or access logs.
+const DebugTokenHeader = "X-Debug-Token"
+
+// debugCookieName is the cookie which allows the client to use the debug tools
+// after the token was posted.
+const debugCookieName = "orivil-debug"
+
+// isDebugRequest checks whether or not the request is a source viewer request.
+func isDebugRequest(r *http.Request) bool {
+
+	return CfgApp.DEBUG && r.URL.Query().Get("debug") == "true"
+}
+
+// debugAllowed checks whether or not the client can use the debug tools, only
+// loopback clients or the clients which sent the right "DEBUG_TOKEN" header or
+// the debug cookie are allowed. The proxy headers are not trusted.
+func debugAllowed(r *http.Request) bool {
+	if hasDebugToken(r) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// hasDebugToken checks the debug token header or the debug cookie, returns
+// false if the token is not configured.
+func hasDebugToken(r *http.Request) bool {
+	if CfgApp.DEBUG_TOKEN == "" {
+		return false
+	}
+	if token := r.Header.Get(DebugTokenHeader); token != "" {
+		return equalDebugToken(token)
+	}
+	c, err := r.Cookie(debugCookieName)
+	return err == nil && subtle.ConstantTimeCompare([]byte(c.Value), []byte(debugCookieValue())) == 1
+}
+
+func equalDebugToken(token string) bool {
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(CfgApp.DEBUG_TOKEN)) == 1
+}
+
+// debugCookieValue derives the cookie value from the token, so the cookie does
+// not reveal the token.
+func debugCookieValue() string {
+	mac := hmac.New(sha256.New, []byte(CfgApp.DEBUG_TOKEN))
+	mac.Write([]byte("orivil debug cookie"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// exchangeDebugToken sets the debug cookie if the right token was posted by the
+// "token" form value, then redirects to the debug URL.
+func (s *Server) exchangeDebugToken(w http.ResponseWriter, r *http.Request) {
+	if CfgApp.DEBUG_TOKEN == "" || !equalDebugToken(r.PostFormValue("token")) {
+		s.handleError(w, r, nil, NewHTTPError(http.StatusNotFound, ""))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     debugCookieName,
+		Value:    debugCookieValue(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
+
+// debugRoots returns the directories the source viewer can read: the project
+// directory, the GOPATH directories and the module cache.
+func debugRoots() (roots []string) {
+	roots = append(roots, DirBase)
+	for _, p := range filepath.SplitList(os.Getenv("GOPATH")) {
+		if p != "" {
+			roots = append(roots, p)
+		}
+	}
+	if cache := os.Getenv("GOMODCACHE"); cache != "" {
+		roots = append(roots, cache)
+	}
+	if home, err := os.UserHomeDir(); err == nil && os.Getenv("GOPATH") == "" {
+		roots = append(roots, filepath.Join(home, "go"))
+	}
+	return
+}
+
+// inDebugRoots checks whether or not the file is under the debug roots, the
+// symbolic links are resolved before checking.
+func inDebugRoots(filename string) (string, bool) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", false
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", false
+	}
+	for _, root := range debugRoots() {
+		if r, err := filepath.EvalSymlinks(root); err == nil {
+			root = r
+		}
+		if withinDir(root, real) {
+			return real, true
+		}
+	}
+	return "", false
+}
+
+type debugLine struct {
+	Num    int
+	Code   template.HTML
+	Target bool
+}
+
+// serveDebugFile shows the source code around the line, it responds 404 if the
+// file is out of the debug roots, or the client is not allowed and no token was
+// configured, otherwise the client is asked for the token.
+func (s *Server) serveDebugFile(w http.ResponseWriter, r *http.Request, urlPath string) {
+	notFound := func() {
+		s.handleError(w, r, nil, NewHTTPError(http.StatusNotFound, ""))
+	}
+	if r.Method == "POST" {
+		s.exchangeDebugToken(w, r)
+		return
+	}
+	if !debugAllowed(r) {
+		if CfgApp.DEBUG_TOKEN == "" {
+			notFound()
+			return
+		}
+		// asks for the token, the form posts it to the same URL
+		w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusForbidden)
+		debugTokenTpl.Execute(w, r.URL.RequestURI())
+		return
+	}
+	q := r.URL.Query()
+	line, err := strconv.Atoi(q.Get("line"))
+	if err != nil || line < 1 {
+		s.handleError(w, r, nil, NewHTTPError(http.StatusBadRequest, "invalid line number"))
+		return
+	}
+	around := debugContextLines
+	if c, err := strconv.Atoi(q.Get("context")); err == nil && c > 0 {
+		around = c
+		if around > debugMaxContextLines {
+			around = debugMaxContextLines
+		}
+	}
+	name, err := url.QueryUnescape(urlPath)
+	if err != nil {
+		notFound()
+		return
+	}
+	name, ok := inDebugRoots(strings.TrimPrefix(name, "/"))
+	if !ok {
+		notFound()
+		return
+	}
+	info, err := os.Stat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		notFound()
+		return
+	}
+	src, err := os.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+
+	var codes []template.HTML
+	if filepath.Ext(name) == ".go" {
+		codes = highlightGo(src)
+	} else {
+		sc := bufio.NewScanner(strings.NewReader(string(src)))
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		for sc.Scan() {
+			codes = append(codes, template.HTML(template.HTMLEscapeString(sc.Text())))
+		}
+	}
+
+	from, to := line-around, line+around
+	if from < 1 {
+		from = 1
+	}
+	if to > len(codes) {
+		to = len(codes)
+	}
+	var lines []debugLine
+	for num := from; num <= to; num++ {
+		lines = append(lines, debugLine{Num: num, Code: codes[num-1], Target: num == line})
+	}
+
+	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	err = debugFileTpl.Execute(w, map[string]interface{}{
+		"file":  name,
+		"lines": lines,
+		"line":  line,
+		"more":  around + debugContextLines,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// highlightGo highlights the Go source code, returns the HTML lines.
+func highlightGo(src []byte) []template.HTML {
+	var lines []template.HTML
+	var cur strings.Builder
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var sc scanner.Scanner
+	sc.Init(file, src, nil, scanner.ScanComments)
+
+	// write writes the text with the class, the spans are closed at the end of
+	// lines, so every line is a valid HTML fragment.
+	write := func(text, class string) {
+		parts := strings.Split(text, "\n")
+		for i, part := range parts {
+			if i > 0 {
+				lines = append(lines, template.HTML(cur.String()))
+				cur.Reset()
+			}
+			if part == "" {
+				continue
+			}
+			if class != "" {
+				cur.WriteString(`<span class="` + class + `">`)
+			}
+			cur.WriteString(template.HTMLEscapeString(part))
+			if class != "" {
+				cur.WriteString("</span>")
+			}
+		}
+	}
+
+	offset := 0
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		start := file.Offset(pos)
+		if start < offset {
+			// the automatically inserted semicolon
+			continue
+		}
+		end := start + len(lit)
+		if lit == "" {
+			end = start + len(tok.String())
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			end = start
+		}
+		if end > len(src) {
+			end = len(src)
+		}
+		write(string(src[offset:start]), "")
+		var class string
+		switch {
+		case tok == token.COMMENT:
+			class = "c"
+		case tok == token.STRING || tok == token.CHAR:
+			class = "s"
+		case tok == token.INT || tok == token.FLOAT || tok == token.IMAG:
+			class = "n"
+		case tok.IsKeyword():
+			class = "k"
+		case tok == token.IDENT && isBuiltinIdent(lit):
+			class = "b"
+		}
+		write(string(src[start:end]), class)
+		offset = end
+	}
+	write(string(src[offset:]), "")
+	lines = append(lines, template.HTML(cur.String()))
+	return lines
+}
+
+func isBuiltinIdent(name string) bool {
+	switch name {
+	case "true", "false", "nil", "iota", "append", "cap", "close", "copy", "delete", "len",
+		"make", "new", "panic", "recover", "print", "println", "error", "string", "int",
+		"int64", "int32", "uint", "uint64", "byte", "rune", "bool", "float64", "interface":
+		return true
+	}
+	return false
+}
+
+var debugFileTpl = template.Must(template.New("debugFile").Parse(`<!doctype html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>File Explorer</title>
+    <style type="text/css">
+        body { font-family: Menlo, Consolas, monospace; font-size: 13px; }
+        table { border-collapse: collapse; }
+        td.num { text-align: right; color: #999; padding-right: 10px; user-select: none; }
+        tr.target { background: #404040; color: #FFFFFF; }
+        tr.target td.num { color: #FFFFFF; }
+        pre { margin: 0; padding-left: 10px; }
+        .k { color: #a626a4; font-weight: bold; }
+        .s { color: #50a14f; }
+        .c { color: #a0a1a7; font-style: italic; }
+        .n { color: #986801; }
+        .b { color: #0184bc; }
+        tr.target .k, tr.target .s, tr.target .c, tr.target .n, tr.target .b { color: inherit; }
+    </style>
+</head>
+<body>
+<p>{{.file}}: {{.line}} <a href="?debug=true&line={{.line}}&context={{.more}}#{{.line}}">more</a></p>
+<table>
+      <tbody>
+      {{range .lines}}
+      <tr{{if .Target}} class="target"{{end}}>
+        <td id="{{.Num}}" class="num">{{.Num}}</td>
+        <td><pre><code>{{.Code}}</code></pre></td>
+      </tr>
+      {{end}}
+</tbody></table>
+</body>
+</html>`))
+
+var debugTokenTpl = template.Must(template.New("debugToken").Parse(`<!doctype html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Debug Token</title>
+</head>
+<body>
+<form method="post" action="{{.}}">
+    <input type="password" name="token" placeholder="debug token" autofocus>
+    <button type="submit">View</button>
+</form>
+</body>
+</html>`))
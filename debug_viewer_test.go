@@ -0,0 +1,225 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setDebugToken sets CfgApp.DEBUG_TOKEN for the test.
+func setDebugToken(t *testing.T, token string) {
+	old := CfgApp.DEBUG_TOKEN
+	CfgApp.DEBUG_TOKEN = token
+	t.Cleanup(func() { CfgApp.DEBUG_TOKEN = old })
+}
+
+// newDebugRoot sets DirBase to a temporary directory which has "main.go" of
+// 500 lines, the other debug roots are empty directories.
+func newDebugRoot(t *testing.T) string {
+	dir := t.TempDir()
+	old := DirBase
+	DirBase = dir
+	t.Cleanup(func() { DirBase = old })
+	t.Setenv("GOPATH", t.TempDir())
+	t.Setenv("GOMODCACHE", "")
+	var src strings.Builder
+	for i := 1; i <= 500; i++ {
+		fmt.Fprintf(&src, "// line %d\n", i)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(strings.TrimSuffix(src.String(), "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestDebugAllowed(t *testing.T) {
+	setDebugToken(t, "secret")
+	tests := []struct {
+		name   string
+		remote string
+		header map[string]string
+		cookie string
+		want   bool
+	}{
+		{"loopback", "127.0.0.1:1234", nil, "", true},
+		{"loopback IPv6", "[::1]:1234", nil, "", true},
+		{"remote", "10.0.0.1:1234", nil, "", false},
+		{"proxy headers", "10.0.0.1:1234", map[string]string{"X-Real-IP": "127.0.0.1", "X-Forwarded-For": "127.0.0.1"}, "", false},
+		{"token header", "10.0.0.1:1234", map[string]string{DebugTokenHeader: "secret"}, "", true},
+		{"wrong token header", "10.0.0.1:1234", map[string]string{DebugTokenHeader: "guess"}, debugCookieValue(), false},
+		{"debug cookie", "10.0.0.1:1234", nil, debugCookieValue(), true},
+		{"token cookie", "10.0.0.1:1234", nil, "secret", false},
+		{"invalid address", "127.0.0.1", nil, "", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/main.go?debug=true&line=1&token=secret", nil)
+		r.RemoteAddr = test.remote
+		for k, v := range test.header {
+			r.Header.Set(k, v)
+		}
+		if test.cookie != "" {
+			r.Header.Set("Cookie", debugCookieName+"="+test.cookie)
+		}
+		if got := debugAllowed(r); got != test.want {
+			t.Errorf("%s: debugAllowed() = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// no token is configured
+	setDebugToken(t, "")
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set(DebugTokenHeader, "")
+	if debugAllowed(r) {
+		t.Error("the empty token was allowed")
+	}
+}
+
+func TestInDebugRoots(t *testing.T) {
+	dir := newDebugRoot(t)
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	os.WriteFile(outside, []byte("secret"), 0644)
+	os.Mkdir(filepath.Join(dir, "vendor"), 0755)
+	if err := os.Symlink(outside, filepath.Join(dir, "vendor", "link.txt")); err != nil {
+		t.Skip(err)
+	}
+	os.Symlink(filepath.Dir(outside), filepath.Join(dir, "linkdir"))
+
+	tests := []struct {
+		file string
+		ok   bool
+	}{
+		{filepath.Join(dir, "main.go"), true},
+		{outside, false},
+		{filepath.Join(dir, "..", filepath.Base(dir), "main.go"), true},
+		{filepath.Join(dir, "..", "main.go"), false},
+		{filepath.Join(dir, "vendor", "link.txt"), false},
+		{filepath.Join(dir, "linkdir", "secret.txt"), false},
+		{filepath.Join(dir, "missing.go"), false},
+	}
+	for _, test := range tests {
+		if _, ok := inDebugRoots(test.file); ok != test.ok {
+			t.Errorf("inDebugRoots(%q) = %v, want %v", test.file, ok, test.ok)
+		}
+	}
+}
+
+// debugRequest requests the "main.go" lines of newDebugRoot from loopback.
+func debugRequest(s *Server, dir, query string) *httptest.ResponseRecorder {
+	urlPath := "/" + url.QueryEscape(filepath.Join(dir, "main.go"))
+	r := httptest.NewRequest("GET", urlPath+"?debug=true&"+query, nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	w := httptest.NewRecorder()
+	s.serveDebugFile(w, r, urlPath)
+	return w
+}
+
+func TestServeDebugFileContext(t *testing.T) {
+	dir := newDebugRoot(t)
+	s := &Server{}
+	tests := []struct {
+		query    string
+		from, to int
+	}{
+		{"line=250", 250 - debugContextLines, 250 + debugContextLines},
+		{"line=250&context=5", 245, 255},
+		{"line=250&context=1000", 250 - debugMaxContextLines, 250 + debugMaxContextLines},
+		{"line=250&context=-1", 250 - debugContextLines, 250 + debugContextLines},
+		{"line=3&context=10", 1, 13},
+		{"line=495&context=10", 485, 500},
+	}
+	for _, test := range tests {
+		w := debugRequest(s, dir, test.query)
+		body := w.Body.String()
+		if w.Code != 200 {
+			t.Errorf("%s: status = %d", test.query, w.Code)
+			continue
+		}
+		lines := strings.Count(body, `class="num"`)
+		if lines != test.to-test.from+1 ||
+			!strings.Contains(body, fmt.Sprintf("// line %d<", test.from)) ||
+			!strings.Contains(body, fmt.Sprintf("// line %d<", test.to)) {
+			t.Errorf("%s: got %d lines, want %d to %d", test.query, lines, test.from, test.to)
+		}
+	}
+	if w := debugRequest(s, dir, "line=0"); w.Code != 400 {
+		t.Errorf("line 0: status = %d, want 400", w.Code)
+	}
+}
+
+func TestServeDebugFileToken(t *testing.T) {
+	dir := newDebugRoot(t)
+	setDebugToken(t, "secret")
+	s := &Server{}
+	urlPath := "/" + url.QueryEscape(filepath.Join(dir, "main.go"))
+	target := urlPath + "?debug=true&line=10"
+
+	// the remote client is asked for the token
+	r := httptest.NewRequest("GET", target+"&token=secret", nil)
+	w := httptest.NewRecorder()
+	s.serveDebugFile(w, r, urlPath)
+	if w.Code != 403 || !strings.Contains(w.Body.String(), `name="token"`) || strings.Contains(w.Body.String(), "// line") {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// the posted token is exchanged for the cookie
+	r = httptest.NewRequest("POST", target, strings.NewReader("token=secret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	s.serveDebugFile(w, r, urlPath)
+	cookies := w.Result().Cookies()
+	if w.Code != 303 || w.Header().Get("Location") != target || len(cookies) != 1 {
+		t.Fatalf("status = %d, Location = %q, cookies = %v", w.Code, w.Header().Get("Location"), cookies)
+	}
+	if c := cookies[0]; c.Name != debugCookieName || c.Value == "secret" || !c.HttpOnly {
+		t.Errorf("cookie = %v", c)
+	}
+
+	r = httptest.NewRequest("GET", target, nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	s.serveDebugFile(w, r, urlPath)
+	if w.Code != 200 || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// the wrong token
+	r = httptest.NewRequest("POST", target, strings.NewReader("token=guess"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	s.serveDebugFile(w, r, urlPath)
+	if w.Code != 404 || len(w.Result().Cookies()) != 0 {
+		t.Errorf("wrong token: status = %d, cookies = %v", w.Code, w.Result().Cookies())
+	}
+}
+
+func TestServeDebugFileNoToken(t *testing.T) {
+	dir := newDebugRoot(t)
+	setDebugToken(t, "")
+	r := httptest.NewRequest("GET", "/main.go?debug=true&line=1", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	w := httptest.NewRecorder()
+	(&Server{}).serveDebugFile(w, r, "/"+url.QueryEscape(filepath.Join(dir, "main.go")))
+	if w.Code != 404 {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestDebugPageLinks(t *testing.T) {
+	setDebugToken(t, "secret")
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(DebugTokenHeader, "secret")
+	w := httptest.NewRecorder()
+	(&Server{}).handleError(w, r, nil, recoverPanic(panicString))
+	if w.Code != 500 || !strings.Contains(w.Body.String(), "debug=true") || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
@@ -12,13 +12,27 @@ import (
 	"fmt"
 	"net"
 	"html/template"
+	"errors"
+	"runtime/debug"
+	"strings"
 )
 
 var debugTpl = template.New("error")
 
 type Trace struct {
-	File string
-	Line int
+	Function string
+	File     string
+	Line     int
+	// App reports whether or not the frame is an application frame, the frames
+	// of the standard library and the framework are not.
+	App bool
+}
+
+// TraceGroup groups the continuous application frames or framework frames,
+// the debug page collapses the framework groups.
+type TraceGroup struct {
+	App    bool
+	Traces []Trace
 }
 
 func init() {
@@ -33,46 +47,196 @@ func init() {
 	}
 }
 
-func handleError(w http.ResponseWriter, r *http.Request, app *App, err error) {
+// PanicError is the error recovered from a panic, it carries the original
+// panic value and the stack of the panic site.
+type PanicError struct {
+	Value  interface{}
+	Stack  []byte
+	Traces []Trace
+}
+
+// NewPanicError wraps the recovered value, it should be called in the deferred
+// function, so the stack contains the panic site. Error values are wrapped too,
+// they can be unwrapped by errors.Is or errors.As.
+func NewPanicError(v interface{}) *PanicError {
+	if e, ok := v.(*PanicError); ok {
+		return e
+	}
+	return &PanicError{Value: v, Stack: debug.Stack(), Traces: panicTraces()}
+}
+
+func (e *PanicError) Error() string {
+	if err, ok := e.Value.(error); ok {
+		return err.Error()
+	}
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
 
-	if err == ErrExitGorountine {
+func (s *Server) handleError(w http.ResponseWriter, r *http.Request, app *App, err error) {
+
+	if errors.Is(err, ErrExitGorountine) {
 		return
 	}
 
-	w.WriteHeader(http.StatusInternalServerError)
+	e := toHTTPError(err)
+
+	// the status and the headers could not be changed if the response was
+	// partially written, only log the error
+	written := false
+	if rw, ok := w.(ResponseWriter); ok {
+		written = rw.Written()
+	}
+
+	// client errors need no trace
+	if e.Code < http.StatusInternalServerError {
+		if written {
+			log.ErrWarnF("%v, but the response was already written: %s %s", err, r.Method, r.URL.String())
+		} else {
+			s.writeError(w, r, app, e)
+		}
+		return
+	}
 
-	skip := 3
+	var traces []Trace
+	if pe, ok := err.(*PanicError); ok {
+		traces = pe.Traces
+	} else {
+		traces = callerTraces(2)
+	}
 	buf := bytes.NewBuffer(nil)
+	for _, t := range traces {
+		mark := " "
+		if t.App {
+			mark = "*"
+		}
+		fmt.Fprintf(buf, "%s %s\n\t%s: %d\n", mark, t.Function, t.File, t.Line)
+	}
+
+	// nothing can be sent if the response was written
+	if !written {
+		if CfgApp.DEBUG && !wantsProblem(r) {
+			w.WriteHeader(e.Code)
+			//errStr := strings.Replace(err.(error).Error(), "\n", "<br>", -1)
+			execErr := debugTpl.Execute(w, map[string]interface{}{
+				"errMsg": err.Error(),
+				"trace":  groupTraces(traces),
+			})
+			if execErr != nil {
+				log.ErrEmergency(execErr)
+			}
+		} else {
+			s.writeError(w, r, app, e)
+		}
+	}
+
+	ip, ipErr := GetIp(r)
+	if ipErr != nil {
+		log.ErrWarn(ipErr)
+	}
+	var action string
+	if app != nil {
+		action = app.Action
+	}
+	log.ErrEmergencyF("http panic:\n[ IP ]: \n %s \n[ REQUEST ]: \n %s %s\n[ ACTION ]: \n %s\n[ USER AGENT ]: \n %s\n[ ERROR ]: \n %v\n[ TRACE ]: \n%s",
+		ip, r.Method, r.URL.String(), action, r.UserAgent(), err, buf)
+}
+
+// panicTraces returns the traces from the panic site, it must be called by the
+// deferred function which recovered the panic.
+func panicTraces() []Trace {
+	traces := callerTraces(0)
+	for i, t := range traces {
+		if t.Function == "runtime.gopanic" {
+			traces = traces[i+1:]
+			// skip the runtime frames which raised the panic, e.g. "runtime.sigpanic"
+			for len(traces) > 0 && strings.HasPrefix(traces[0].Function, "runtime.") {
+				traces = traces[1:]
+			}
+			return traces
+		}
+	}
+	return traces
+}
+
+// callerTraces returns the traces of the caller stack, skip 0 is the caller
+// of callerTraces.
+func callerTraces(skip int) []Trace {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
 	var traces []Trace
 	for {
-		_, file, line, ok := runtime.Caller(skip)
-		if !ok {
+		frame, more := frames.Next()
+		traces = append(traces, Trace{
+			Function: strings.Replace(frame.Function, "%2e", ".", -1),
+			File:     frame.File,
+			Line:     frame.Line,
+			App:      isAppFunc(frame.Function),
+		})
+		if !more {
 			break
 		}
-		traces = append(traces, Trace{File: file, Line: line})
-		msg := fmt.Sprintf("%s: %d\n", file, line)
-		buf.WriteString(msg)
-		skip++
 	}
+	return traces
+}
 
-	if CfgApp.DEBUG {
-		//errStr := strings.Replace(err.(error).Error(), "\n", "<br>", -1)
-		execErr := debugTpl.Execute(w, map[string]interface{}{
-			"errMsg": err.Error(),
-			"trace":  traces,
-		})
-		if execErr != nil {
-			log.ErrEmergency(execErr)
+// isAppFunc checks whether or not the function belongs to the application, the
+// functions of the standard library and the orivil packages are not, except the
+// examples.
+func isAppFunc(function string) bool {
+	pkg := function
+	if idx := strings.LastIndex(pkg, "/"); idx >= 0 {
+		if dot := strings.Index(pkg[idx:], "."); dot >= 0 {
+			pkg = pkg[:idx+dot]
 		}
-	} else {
-		w.Write(internalErrorPage)
+	} else if dot := strings.Index(pkg, "."); dot >= 0 {
+		pkg = pkg[:dot]
+	}
+	if pkg == "main" {
+		return true
+	}
+	first := strings.Split(pkg, "/")[0]
+	if !strings.Contains(first, ".") {
+		// standard library
+		return false
 	}
+	if strings.HasPrefix(pkg, "gopkg.in/orivil/") && !strings.Contains(pkg, "/example/") {
+		return false
+	}
+	return true
+}
+
+func groupTraces(traces []Trace) (groups []TraceGroup) {
+	for _, t := range traces {
+		if len(groups) == 0 || groups[len(groups)-1].App != t.App {
+			groups = append(groups, TraceGroup{App: t.App})
+		}
+		last := &groups[len(groups)-1]
+		last.Traces = append(last.Traces, t)
+	}
+	return
+}
 
-	ip, e := GetIp(r)
-	if e != nil {
-		log.ErrWarn(e)
+// writeError sends the error by the registered error handler, or sends the
+// default problem details or error page.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, app *App, e *HTTPError) {
+	var action string
+	if app != nil {
+		action = app.Action
+	}
+	if h := s.ErrorHandler(action, e.Code); h != nil && s.callErrorHandler(h, w, r, app, e) {
+		return
+	}
+	if wantsProblem(r) {
+		writeProblem(w, r, e)
+	} else {
+		writeErrorPage(w, e)
 	}
-	log.ErrEmergencyF("http panic:\n[ IP ]: \n %s \n[ URL ]: \n %s\n[ ERROR ]: \n %v\n[ TRACE ]: \n%s", ip, r.URL.String(), err, buf)
 }
 
 func GetIp(r *http.Request) (net.IP, error) {
@@ -97,54 +261,6 @@ func GetIp(r *http.Request) (net.IP, error) {
 	return userIP, nil
 }
 
-var internalErrorPage = []byte(`<!doctype html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <title>500 Internal Error</title>
-</head>
-<style>
-#warp {
-  position: absolute;
-  width:700px;
-  height:200px;
-  left:50%;
-  top:50%;
-  margin-left:-250px;
-  margin-top:-100px;
-}
-</style>
-<body>
-  <div id="warp">
-  	<h1>Whoops! 500 Internal Server Error</h1>
-  </div>
-</body>
-</html>`)
-
-var notFoundPage = []byte(`<!doctype html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <title>404 Not Found</title>
-</head>
-<style>
-#warp {
-  position: absolute;
-  width:700px;
-  height:200px;
-  left:50%;
-  top:50%;
-  margin-left:-250px;
-  margin-top:-100px;
-}
-</style>
-<body>
-  <div id="warp">
-  	<h1>Whoops! 404 Page Not Found</h1>
-  </div>
-</body>
-</html>`)
-
 var debugTemplate = `<!doctype html>
 <html lang="en">
 <head>
@@ -251,6 +367,17 @@ var debugTemplate = `<!doctype html>
 		a {
 			background: transparent;
 		}
+		.app-frame {
+			background-color: #fcf8e3;
+			border-left: 4px solid #a94442;
+		}
+		.framework-frame, .framework-frame a {
+			color: #777;
+			font-size: 90%;
+		}
+		.framework-frame div {
+			padding: 4px 0;
+		}
     </style>
 </head>
 <body>
@@ -263,7 +390,20 @@ var debugTemplate = `<!doctype html>
 			  <div class="panel-heading">Trace:</div>
 			  <ul class="list-group">
 				{{range .trace}}
-				<li class="list-group-item"><a href="/{{urlquery .File}}?debug=true&line={{.Line}}#{{minus .Line 10}}" target="_blank">{{.File}}: {{.Line}}</a></li>
+				{{if .App}}
+				{{range .Traces}}
+				<li class="list-group-item app-frame"><a href="/{{urlquery .File}}?debug=true&line={{.Line}}#{{minus .Line 10}}" target="_blank"><strong>{{.Function}}</strong><br>{{.File}}: {{.Line}}</a></li>
+				{{end}}
+				{{else}}
+				<li class="list-group-item framework-frame">
+					<details>
+						<summary>{{len .Traces}} framework frames</summary>
+						{{range .Traces}}
+						<div><a href="/{{urlquery .File}}?debug=true&line={{.Line}}#{{minus .Line 10}}" target="_blank">{{.Function}}<br>{{.File}}: {{.Line}}</a></div>
+						{{end}}
+					</details>
+				</li>
+				{{end}}
 				{{end}}
 			  </ul>
 			</div>
@@ -271,26 +411,3 @@ var debugTemplate = `<!doctype html>
     </div>
 </body>
 </html>`
-
-var debugFileTemplate = `<!doctype html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <title>File Explorer</title>
-</head>
-<body>
-<table>
-      <tbody>
-      {{range $idx, $file := .lines}}
-      <tr>
-        <td id="{{add $idx 1}}" style="text-align:right">{{add $idx 1}}</td>
-      	{{if (add $idx 1|eq $.line)}}
-        <td id="LC1" style="background:#404040; color:#FFFFFF"><pre style="margin:0;padding-left:10px;"><code>{{$file}}</code></pre></td>
-        {{else}}
-        <td id="LC1"><pre style="margin:0;padding-left:10px;"><code>{{$file}}</code></pre></td>
-        {{end}}
-      </tr>
-      {{end}}
-</tbody></table>
-</body>
-</html>`
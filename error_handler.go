@@ -0,0 +1,122 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"gopkg.in/orivil/log.v0"
+	"net/http"
+	"strings"
+)
+
+// AnyStatus is the status code for registering the fallback error handler.
+const AnyStatus = 0
+
+// ErrorHandler handles the error responses. The app is ready to use, the
+// handler could set view pages and data like a controller action, e.g.
+//
+//	app.ViewBundle("myBundle", "404").With("error", e)
+//
+// or write the response directly. The status code will be sent before the
+// first write. If nothing was set or written, the default error page is sent.
+type ErrorHandler interface {
+	HandleError(app *App, e *HTTPError)
+}
+
+// ErrorHandlerFunc is an adapter to allow the use of ordinary functions as error handlers.
+type ErrorHandlerFunc func(app *App, e *HTTPError)
+
+func (f ErrorHandlerFunc) HandleError(app *App, e *HTTPError) {
+
+	f(app, e)
+}
+
+// SetErrorHandler sets the error handler of the status code, use AnyStatus to
+// handle all of the statuses which have no handlers. Bundles usually set them
+// in "Boot".
+func (s *Server) SetErrorHandler(code int, h ErrorHandler) {
+
+	s.SetBundleErrorHandler("", code, h)
+}
+
+// SetBundleErrorHandler sets the error handler which only handles the errors of
+// the bundle actions, it takes precedence over the handler set by SetErrorHandler.
+func (s *Server) SetBundleErrorHandler(bundle string, code int, h ErrorHandler) {
+	if s.errorHandlers[bundle] == nil {
+		s.errorHandlers[bundle] = make(map[int]ErrorHandler, 1)
+	}
+	s.errorHandlers[bundle][code] = h
+}
+
+// ErrorHandler returns the error handler for the action and the status code,
+// returns nil if no handler was set.
+func (s *Server) ErrorHandler(action string, code int) ErrorHandler {
+	var bundle string
+	if idx := strings.Index(action, "."); idx > 0 {
+		bundle = action[:idx]
+	}
+	for _, b := range []string{bundle, ""} {
+		if handlers, ok := s.errorHandlers[b]; ok {
+			if h, ok := handlers[code]; ok {
+				return h
+			}
+			if h, ok := handlers[AnyStatus]; ok {
+				return h
+			}
+		}
+	}
+	return nil
+}
+
+// callErrorHandler calls the handler and sends its response, returns false if
+// the handler responded nothing.
+func (s *Server) callErrorHandler(h ErrorHandler, w http.ResponseWriter, r *http.Request, app *App, e *HTTPError) (ok bool) {
+	if app == nil {
+		app = s.newApp(w, r, "", nil)
+		w = app.Response
+	}
+	// the first write sends the error status instead of the implicit 200
+	writer := &statusWriter{ResponseWriter: w, code: e.Code}
+	app.Response = writer
+	app.viewPages = nil
+	app.data = make(map[string]interface{}, 1)
+	app.mediaType = ""
+	app.rendered = false
+
+	defer func() {
+		if err := recover(); err != nil {
+			if err != ErrExitGorountine {
+				log.ErrEmergencyF("error handler of status %d panic: %v", e.Code, err)
+			}
+			ok = writer.wrote
+		}
+	}()
+
+	h.HandleError(app, e)
+	if !writer.wrote && app.viewPages == nil && len(app.data) == 0 {
+		return false
+	}
+	app.flash()
+	writer.WriteHeader(e.Code)
+	return true
+}
+
+// statusWriter sends the status code before the first write.
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	wrote bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.wrote = true
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(w.code)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newErrorServer returns the server which renders the data by JSON.
+func newErrorServer() *Server {
+	s := &Server{
+		errorHandlers: make(map[string]map[int]ErrorHandler),
+		renderers:     make(map[string]Renderer),
+		formats:       make(map[string]string),
+	}
+	s.SetRenderer(MediaJSON, RendererFunc(renderJSON), "json")
+	return s
+}
+
+// codeHandler writes its name, so tests know which handler responded.
+func codeHandler(name string) ErrorHandler {
+	return ErrorHandlerFunc(func(app *App, e *HTTPError) {
+		app.Response.Write([]byte(name))
+	})
+}
+
+func TestErrorHandlerLookup(t *testing.T) {
+	s := newErrorServer()
+	s.SetErrorHandler(404, codeHandler("404"))
+	s.SetErrorHandler(AnyStatus, codeHandler("any"))
+	s.SetBundleErrorHandler("admin", 403, codeHandler("admin 403"))
+
+	tests := []struct {
+		action string
+		code   int
+		want   string
+	}{
+		{"user.Controller.Show", 404, "404"},
+		{"user.Controller.Show", 500, "any"},
+		{"", 404, "404"},
+		{"admin.Controller.Index", 403, "admin 403"},
+		{"admin.Controller.Index", 404, "404"},
+		{"user.Controller.Show", 403, "any"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h := s.ErrorHandler(test.action, test.code)
+		if h == nil {
+			t.Errorf("ErrorHandler(%q, %d) = nil", test.action, test.code)
+			continue
+		}
+		h.HandleError(&App{Response: w}, NewHTTPError(test.code, ""))
+		if got := w.Body.String(); got != test.want {
+			t.Errorf("ErrorHandler(%q, %d) is %q, want %q", test.action, test.code, got, test.want)
+		}
+	}
+	if h := newErrorServer().ErrorHandler("user.Controller.Show", 404); h != nil {
+		t.Errorf("ErrorHandler() = %v, want nil", h)
+	}
+}
+
+func TestWriteErrorByHandler(t *testing.T) {
+	s := newErrorServer()
+	s.SetErrorHandler(404, ErrorHandlerFunc(func(app *App, e *HTTPError) {
+		app.With("error", e.Message)
+	}))
+	w := httptest.NewRecorder()
+	s.writeError(w, httptest.NewRequest("GET", "/", nil), nil, NewHTTPError(404, "no user"))
+	if w.Code != 404 || strings.TrimSpace(w.Body.String()) != `{"error":"no user"}` {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWriteErrorHandlerRespondedNothing(t *testing.T) {
+	s := newErrorServer()
+	s.SetErrorHandler(AnyStatus, ErrorHandlerFunc(func(app *App, e *HTTPError) {}))
+	w := httptest.NewRecorder()
+	s.writeError(w, httptest.NewRequest("GET", "/", nil), nil, NewHTTPError(404, "no user"))
+	if w.Code != 404 || !strings.Contains(w.Body.String(), "Whoops! 404 Not Found") {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWriteErrorHandlerPanics(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler ErrorHandlerFunc
+		body    string
+	}{
+		{"before writing", func(app *App, e *HTTPError) {
+			panic(errors.New("template not found"))
+		}, "Whoops! 500 Internal Server Error"},
+		{"after writing", func(app *App, e *HTTPError) {
+			app.Response.Write([]byte("partial"))
+			panic(errors.New("template not found"))
+		}, "partial"},
+		{"exit", func(app *App, e *HTTPError) {
+			app.Response.Write([]byte("exited"))
+			panic(ErrExitGorountine)
+		}, "exited"},
+	}
+	for _, test := range tests {
+		s := newErrorServer()
+		s.SetErrorHandler(500, test.handler)
+		w := httptest.NewRecorder()
+		s.writeError(w, httptest.NewRequest("GET", "/", nil), nil, NewHTTPError(500, ""))
+		if w.Code != 500 || !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s: status = %d, body = %s", test.name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCallErrorHandlerResetsApp(t *testing.T) {
+	s := newErrorServer()
+	w := httptest.NewRecorder()
+	app := s.newApp(w, httptest.NewRequest("GET", "/", nil), "user.Controller.Show", nil)
+	app.With("user", "unfinished")
+	app.mediaType = MediaXML
+	app.rendered = true
+
+	h := ErrorHandlerFunc(func(app *App, e *HTTPError) {
+		app.With("error", e.Message)
+	})
+	if !s.callErrorHandler(h, w, app.Request, app, NewHTTPError(409, "the name already exists")) {
+		t.Fatal("the handler responded nothing")
+	}
+	if w.Code != 409 || strings.TrimSpace(w.Body.String()) != `{"error":"the name already exists"}` {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func panicString() { panic("x") }
+
+func panicInt() { panic(42) }
+
+func panicNil() {
+	var app *App
+	app.Action = "nil"
+}
+
+// recoverPanic calls f and returns its panic as *PanicError.
+func recoverPanic(f func()) (e *PanicError) {
+	defer func() {
+		e = NewPanicError(recover())
+	}()
+	f()
+	return
+}
+
+func TestNewPanicError(t *testing.T) {
+	tests := []struct {
+		f        func()
+		value    interface{}
+		message  string
+		function string
+	}{
+		{panicString, "x", "panic: x", ".panicString"},
+		{panicInt, 42, "panic: 42", ".panicInt"},
+		{panicNil, nil, "nil pointer dereference", ".panicNil"},
+	}
+	for _, test := range tests {
+		e := recoverPanic(test.f)
+		if test.value != nil && e.Value != test.value {
+			t.Errorf("%s: value = %v, want %v", test.function, e.Value, test.value)
+		}
+		if !strings.Contains(e.Error(), test.message) {
+			t.Errorf("%s: message = %q, want %q", test.function, e.Error(), test.message)
+		}
+		if len(e.Traces) == 0 || !strings.HasSuffix(e.Traces[0].Function, test.function) {
+			t.Errorf("%s: the first trace is not the panic site: %v", test.function, e.Traces)
+		}
+		if len(e.Stack) == 0 {
+			t.Errorf("%s: no stack", test.function)
+		}
+	}
+}
+
+func TestPanicErrorUnwrap(t *testing.T) {
+	err := NewHTTPError(403, "")
+	e := recoverPanic(func() { panic(err) })
+	if e.Error() != err.Error() || !errors.Is(e, err) {
+		t.Errorf("PanicError(%v) does not wrap the error", e)
+	}
+	if NewPanicError(e) != e {
+		t.Error("PanicError was wrapped twice")
+	}
+	if e := recoverPanic(panicString); e.Unwrap() != nil {
+		t.Errorf("Unwrap() = %v, want nil", e.Unwrap())
+	}
+}
+
+func TestIsAppFunc(t *testing.T) {
+	tests := []struct {
+		function string
+		app      bool
+	}{
+		{"main.main", true},
+		{"main.(*Controller).Index.func1", true},
+		{"runtime.gopanic", false},
+		{"net/http.HandlerFunc.ServeHTTP", false},
+		{"reflect.Value.Call", false},
+		{"gopkg.in/orivil/orivil.v2.(*Server).ServeHTTP", false},
+		{"gopkg.in/orivil/router.v0.(*Container).Match", false},
+		{"gopkg.in/orivil/orivil.v2/example/bundle/debug.(*Controller).Index", true},
+		{"github.com/me/shop/bundle/user.(*Controller).Show", true},
+		{"example.com/shop.v2.Run", true},
+	}
+	for _, test := range tests {
+		if got := isAppFunc(test.function); got != test.app {
+			t.Errorf("isAppFunc(%q) = %v, want %v", test.function, got, test.app)
+		}
+	}
+}
+
+func TestGroupTraces(t *testing.T) {
+	traces := []Trace{
+		{Function: "user.Show", App: true},
+		{Function: "user.find", App: true},
+		{Function: "reflect.Value.Call"},
+		{Function: "orivil.(*invoker).call"},
+		{Function: "user.Auth", App: true},
+		{Function: "net/http.serverHandler.ServeHTTP"},
+	}
+	want := []TraceGroup{
+		{App: true, Traces: traces[0:2]},
+		{App: false, Traces: traces[2:4]},
+		{App: true, Traces: traces[4:5]},
+		{App: false, Traces: traces[5:6]},
+	}
+	if got := groupTraces(traces); !reflect.DeepEqual(got, want) {
+		t.Errorf("groupTraces() = %v, want %v", got, want)
+	}
+	if got := groupTraces(nil); got != nil {
+		t.Errorf("groupTraces(nil) = %v, want nil", got)
+	}
+}
+
+func TestPanicTracesGrouping(t *testing.T) {
+	// the test functions belong to the framework package, the runtime and the
+	// testing frames are framework frames too
+	e := recoverPanic(panicString)
+	for _, tr := range e.Traces {
+		if tr.App {
+			t.Errorf("%s is an application frame", tr.Function)
+		}
+		if tr.Function == "runtime.gopanic" {
+			t.Errorf("the runtime panic frame was not skipped")
+		}
+	}
+	if groups := groupTraces(e.Traces); len(groups) != 1 || groups[0].App {
+		t.Errorf("groups = %v", groups)
+	}
+}
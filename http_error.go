@@ -0,0 +1,168 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// MediaProblemJSON is the media type of RFC 7807 problem details.
+const MediaProblemJSON = "application/problem+json"
+
+// HTTPError is the error which will be responded with its status code, the
+// message is shown to clients, the cause is only logged.
+//
+// Usage:
+//
+//	panic(orivil.NewHTTPError(409, "the name already exists", err))
+//
+// or:
+//
+//	app.Abort(403, "permission denied")
+type HTTPError struct {
+	Code    int
+	Message string
+	Cause   error
+}
+
+// NewHTTPError creates an HTTPError, the message will be the status text if
+// it is empty.
+func NewHTTPError(code int, message string, cause ...error) *HTTPError {
+	if message == "" {
+		message = http.StatusText(code)
+	}
+	e := &HTTPError{Code: code, Message: message}
+	if len(cause) > 0 {
+		e.Cause = cause[0]
+	}
+	return e
+}
+
+func (e *HTTPError) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+	return e.Message
+}
+
+func (e *HTTPError) Unwrap() error {
+
+	return e.Cause
+}
+
+// Abort terminates the HTTP goroutine, and responds the error with the status code.
+func (app *App) Abort(code int, message string, cause ...error) {
+
+	panic(NewHTTPError(code, message, cause...))
+}
+
+// Problem is the RFC 7807 problem details object, the "errors" extension member
+// is the field errors of the request binding, see App.Bind.
+type Problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Errors   FieldErrors `json:"errors,omitempty"`
+}
+
+// fieldErrors returns the field errors which caused the error.
+func fieldErrors(e *HTTPError) FieldErrors {
+	var errs FieldErrors
+	errors.As(e.Cause, &errs)
+	return errs
+}
+
+// toHTTPError converts any error to *HTTPError, unknown errors are 500 errors
+// and their messages are not shown to clients except debug mode.
+func toHTTPError(err error) *HTTPError {
+	var e *HTTPError
+	if errors.As(err, &e) {
+		return e
+	}
+	message := http.StatusText(http.StatusInternalServerError)
+	if CfgApp.DEBUG {
+		message = err.Error()
+	}
+	return &HTTPError{Code: http.StatusInternalServerError, Message: message, Cause: err}
+}
+
+// wantsProblem checks whether or not the client is an API client.
+func wantsProblem(r *http.Request) bool {
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	offers := []string{MediaHTML, MediaProblemJSON, MediaJSON}
+	return Negotiate(accept, offers, MediaHTML) != MediaHTML
+}
+
+// writeProblem writes the error as RFC 7807 problem details.
+func writeProblem(w http.ResponseWriter, r *http.Request, e *HTTPError) {
+	w.Header().Set("Content-Type", MediaProblemJSON+";charset=UTF-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(&Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(e.Code),
+		Status:   e.Code,
+		Detail:   e.Message,
+		Instance: r.URL.Path,
+		Errors:   fieldErrors(e),
+	})
+}
+
+// writeErrorPage writes the default HTML error page of the status code.
+func writeErrorPage(w http.ResponseWriter, e *HTTPError) {
+	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(e.Code)
+	message := e.Message
+	if strings.EqualFold(message, http.StatusText(e.Code)) {
+		message = ""
+	}
+	errorPageTpl.Execute(w, map[string]interface{}{
+		"code":    e.Code,
+		"title":   http.StatusText(e.Code),
+		"message": message,
+		"errors":  fieldErrors(e),
+	})
+}
+
+var errorPageTpl = template.Must(template.New("errorPage").Parse(`<!doctype html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>{{.code}} {{.title}}</title>
+</head>
+<style>
+#warp {
+  position: absolute;
+  width:700px;
+  height:200px;
+  left:50%;
+  top:50%;
+  margin-left:-250px;
+  margin-top:-100px;
+}
+</style>
+<body>
+  <div id="warp">
+  	<h1>Whoops! {{.code}} {{.title}}</h1>
+  	{{if .message}}<p>{{.message}}</p>{{end}}
+  	{{if .errors}}<ul>{{range .errors}}<li>{{.Field}}: {{.Message}}</li>{{end}}</ul>{{end}}
+  </div>
+</body>
+</html>`))
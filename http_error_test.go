@@ -0,0 +1,127 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToHTTPErrorWrapped(t *testing.T) {
+	e := NewHTTPError(409, "the name already exists")
+	wrapped := fmt.Errorf("create user: %w", NewPanicError(fmt.Errorf("save: %w", e)))
+	if got := toHTTPError(wrapped); got != e {
+		t.Errorf("toHTTPError() = %v, want %v", got, e)
+	}
+	var target *HTTPError
+	if !errors.As(wrapped, &target) || target.Code != 409 {
+		t.Errorf("errors.As() = %v", target)
+	}
+}
+
+func TestToHTTPErrorHidesMessage(t *testing.T) {
+	debug := CfgApp.DEBUG
+	defer func() { CfgApp.DEBUG = debug }()
+
+	err := errors.New("dial tcp 10.0.0.1:3306: connection refused")
+	CfgApp.DEBUG = false
+	if e := toHTTPError(err); e.Code != 500 || e.Message != "Internal Server Error" || e.Cause != err {
+		t.Errorf("toHTTPError() = %+v", e)
+	}
+	CfgApp.DEBUG = true
+	if e := toHTTPError(err); e.Message != err.Error() {
+		t.Errorf("debug toHTTPError() = %+v", e)
+	}
+}
+
+func TestWantsProblem(t *testing.T) {
+	tests := []struct {
+		accept, requestedWith string
+		want                  bool
+	}{
+		{"", "", false},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "", false},
+		{"application/json", "", true},
+		{"application/problem+json", "", true},
+		{"*/*", "XMLHttpRequest", true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", test.accept)
+		r.Header.Set("X-Requested-With", test.requestedWith)
+		if got := wantsProblem(r); got != test.want {
+			t.Errorf("wantsProblem(%q, %q) = %v, want %v", test.accept, test.requestedWith, got, test.want)
+		}
+	}
+}
+
+func TestHandleErrorProblem(t *testing.T) {
+	s := &Server{}
+	r := httptest.NewRequest("GET", "/users/5", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	s.handleError(w, r, nil, fmt.Errorf("find user: %w", NewHTTPError(404, "the user does not exist")))
+
+	if w.Code != 404 || !strings.HasPrefix(w.Header().Get("Content-Type"), MediaProblemJSON) {
+		t.Fatalf("status = %d, content type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	want := Problem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "the user does not exist", Instance: "/users/5"}
+	if !reflect.DeepEqual(p, want) || strings.Contains(w.Body.String(), `"errors"`) {
+		t.Errorf("problem = %s, want %+v", w.Body.String(), want)
+	}
+}
+
+func TestHandleErrorPage(t *testing.T) {
+	debug := CfgApp.DEBUG
+	defer func() { CfgApp.DEBUG = debug }()
+	CfgApp.DEBUG = false
+
+	tests := []struct {
+		err  error
+		code int
+		show string
+		hide string
+	}{
+		{NewHTTPError(403, "permission denied"), 403, "permission denied", ""},
+		{errors.New("password=secret"), 500, "500 Internal Server Error", "password=secret"},
+		{NewPanicError("password=secret"), 500, "500 Internal Server Error", "password=secret"},
+	}
+	for _, test := range tests {
+		s := &Server{}
+		w := httptest.NewRecorder()
+		s.handleError(w, httptest.NewRequest("GET", "/", nil), nil, test.err)
+		body := w.Body.String()
+		if w.Code != test.code || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%v: status = %d, content type = %q", test.err, w.Code, w.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(body, test.show) || test.hide != "" && strings.Contains(body, test.hide) {
+			t.Errorf("%v: body = %s", test.err, body)
+		}
+	}
+}
+
+func TestHandleErrorProblemHidesMessage(t *testing.T) {
+	debug := CfgApp.DEBUG
+	defer func() { CfgApp.DEBUG = debug }()
+	CfgApp.DEBUG = false
+
+	s := &Server{}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", MediaProblemJSON)
+	w := httptest.NewRecorder()
+	s.handleError(w, r, nil, errors.New("password=secret"))
+	if w.Code != 500 || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
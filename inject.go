@@ -0,0 +1,192 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"fmt"
+	"gopkg.in/orivil/service.v0"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var appType = reflect.TypeOf((*App)(nil))
+
+// injection is the controller field which is set before the action is called.
+type injection struct {
+	index   []int  // the field index, the nil embedded pointers are allocated
+	name    string // the field name, e.g. "Controller.Base.Mailer"
+	service string // empty for the *App field
+}
+
+// AddService registers the service provider to the public service container
+// and declares the type of the service for the controller injection, the typ
+// is a nil pointer of the service type, e.g. (*Mailer)(nil), or
+// (*mail.Sender)(nil) for the interface type. The tagged controller fields and
+// the exported fields of the declared interface types are set from the private
+// service container before the action is called:
+//
+//	*orivil.App                            // the current app, no tag is needed
+//	Mailer  *Mailer     `inject:"svc.mailer"` // the service name
+//	Sender  mail.Sender `inject:""`           // the service of the interface type
+//	Backup  mail.Sender                      // the same, no tag is needed
+//
+// The other untagged fields are not injected, so the values set by the
+// controller provider are kept. The embedded structs of the controller are
+// traversed, the tags which refer to the services not added or the mismatched
+// types are reported when the server starts. Use `inject:"-"` to skip a field
+// or an embedded struct.
+//
+// Usage:
+//
+//	func (*Register) Boot(s *orivil.Server) {
+//		s.AddService("svc.mailer", (*Mailer)(nil), func(c *service.Container) interface{} {
+//			return NewMailer()
+//		})
+//		s.AddService("mail.Sender", (*mail.Sender)(nil), func(c *service.Container) interface{} {
+//			return c.Get("svc.mailer")
+//		})
+//	}
+func (s *Server) AddService(service string, typ interface{}, provider service.Provider) {
+	if provider == nil {
+		panic(fmt.Errorf("add service %q: the provider is nil", service))
+	}
+	s.declareService(service, typ)
+	s.SContainer.Add(service, provider)
+}
+
+// declareService declares the type of the service which is registered to the
+// service container, e.g. the services of BaseRegister.
+func (s *Server) declareService(service string, typ interface{}) {
+	t := reflect.TypeOf(typ)
+	if t == nil || t.Kind() != reflect.Ptr {
+		panic(fmt.Errorf("declare service %q: the type must be a nil pointer, got %T", service, typ))
+	}
+	if t.Elem().Kind() == reflect.Interface {
+		t = t.Elem()
+		s.interfaces[t] = service
+	}
+	s.serviceTypes[service] = t
+}
+
+// loadInjections plans the injections of the controllers, it panics if any
+// dependency can not be resolved.
+func (s *Server) loadInjections(providers map[string]func() interface{}) {
+	var errs []string
+	for name, provider := range providers {
+		t := reflect.TypeOf(provider())
+		if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+			errs = append(errs, fmt.Sprintf("%s: the controller must be a struct pointer, got %v", name, t))
+			continue
+		}
+		injections, es := s.planInjections(t.Elem(), nil, t.Elem().Name(), make(map[reflect.Type]bool))
+		s.injections[t.Elem()] = injections
+		errs = append(errs, es...)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		panic(fmt.Errorf("controller dependencies:\n\t%s", strings.Join(errs, "\n\t")))
+	}
+}
+
+// planInjections returns the injections of the struct type and its embedded
+// structs.
+func (s *Server) planInjections(t reflect.Type, index []int, prefix string, visited map[reflect.Type]bool) (injections []injection, errs []string) {
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		in := injection{
+			index: append(append([]int{}, index...), i),
+			name:  prefix + "." + f.Name,
+		}
+		tag, tagged := f.Tag.Lookup("inject")
+		switch {
+		case tag == "-":
+		case f.Type == appType:
+			if f.IsExported() {
+				injections = append(injections, in)
+			}
+		case tagged:
+			if !f.IsExported() {
+				errs = append(errs, fmt.Sprintf("%s: the unexported field can not be injected", in.name))
+				continue
+			}
+			if tag == "" {
+				service, ok := s.interfaces[f.Type]
+				if !ok {
+					errs = append(errs, fmt.Sprintf("%s: no service was added for %v", in.name, f.Type))
+					continue
+				}
+				tag = service
+			}
+			typ, ok := s.serviceTypes[tag]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s: service %q was not added", in.name, tag))
+				continue
+			}
+			if !typ.AssignableTo(f.Type) {
+				errs = append(errs, fmt.Sprintf("%s: service %q(%v) is not assignable to %v", in.name, tag, typ, f.Type))
+				continue
+			}
+			in.service = tag
+			injections = append(injections, in)
+		case f.IsExported() && s.interfaces[f.Type] != "":
+			in.service = s.interfaces[f.Type]
+			injections = append(injections, in)
+		case f.Anonymous:
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() != reflect.Struct || visited[ft] {
+				continue
+			}
+			sub, es := s.planInjections(ft, in.index, in.name, visited)
+			errs = append(errs, es...)
+			if len(sub) > 0 && f.Type.Kind() == reflect.Ptr && !f.IsExported() {
+				errs = append(errs, fmt.Sprintf("%s: the unexported embedded pointer can not be allocated", in.name))
+				continue
+			}
+			injections = append(injections, sub...)
+		}
+	}
+	delete(visited, t)
+	return
+}
+
+// inject sets the controller fields planned by planInjections.
+func inject(v reflect.Value, injections []injection, app *App) {
+	for _, in := range injections {
+		f := fieldByIndex(v, in.index)
+		if in.service == "" {
+			f.Set(reflect.ValueOf(app))
+			continue
+		}
+		service := app.Container.Get(in.service)
+		if service == nil {
+			panic(fmt.Errorf("inject %s: service %q not found", in.name, in.service))
+		}
+		value := reflect.ValueOf(service)
+		if !value.Type().AssignableTo(f.Type()) {
+			panic(fmt.Errorf("inject %s: service %q(%v) is not assignable to %v", in.name, in.service, value.Type(), f.Type()))
+		}
+		f.Set(value)
+	}
+}
+
+// fieldByIndex returns the nested field, the nil embedded pointers on the way
+// are allocated.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
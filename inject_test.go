@@ -0,0 +1,99 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"gopkg.in/orivil/service.v0"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type injectMailer struct{}
+
+type injectSender interface {
+	Send(to string) error
+}
+
+type injectBase struct {
+	Mailer *injectMailer `inject:"svc.mailer"`
+}
+
+type injectController struct {
+	injectBase
+	App      *App
+	Sender   injectSender  `inject:""`
+	Fallback injectSender  // not tagged, the service of the interface type
+	Kept     *injectMailer // not tagged, kept as the provider set it
+	Skipped  *injectBase   `inject:"-"`
+	Ignored  injectSender  `inject:"-"`
+	sender   injectSender
+}
+
+func newInjectServer() *Server {
+	s := &Server{
+		SContainer:   service.NewPublicContainer(),
+		serviceTypes: make(map[string]reflect.Type),
+		interfaces:   make(map[reflect.Type]string),
+	}
+	s.AddService("svc.mailer", (*injectMailer)(nil), func(c *service.Container) interface{} {
+		return new(injectMailer)
+	})
+	return s
+}
+
+func TestPlanInjections(t *testing.T) {
+	s := newInjectServer()
+	s.AddService("inject.Sender", (*injectSender)(nil), func(c *service.Container) interface{} {
+		return nil
+	})
+	typ := reflect.TypeOf(injectController{})
+	injections, errs := s.planInjections(typ, nil, typ.Name(), make(map[reflect.Type]bool))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	var got []string
+	for _, in := range injections {
+		got = append(got, in.name+"="+in.service)
+	}
+	want := []string{
+		"injectController.injectBase.Mailer=svc.mailer",
+		"injectController.App=",
+		"injectController.Sender=inject.Sender",
+		"injectController.Fallback=inject.Sender",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("injections = %q, want %q", got, want)
+	}
+}
+
+func TestPlanInjectionsNotAdded(t *testing.T) {
+	s := newInjectServer()
+	typ := reflect.TypeOf(struct {
+		Sender injectSender  `inject:""`
+		Cache  *injectMailer `inject:"svc.cache"`
+		Other  *injectBase   `inject:"svc.mailer"`
+		Kept   injectSender  // not tagged, no service was added
+	}{})
+	_, errs := s.planInjections(typ, nil, "Controller", make(map[reflect.Type]bool))
+	want := []string{
+		"Controller.Sender: no service was added for orivil.injectSender",
+		`Controller.Cache: service "svc.cache" was not added`,
+		`Controller.Other: service "svc.mailer"(*orivil.injectMailer) is not assignable to *orivil.injectBase`,
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("errors = %q, want %q", errs, want)
+	}
+}
+
+func TestAddServiceNilProvider(t *testing.T) {
+	s := newInjectServer()
+	defer func() {
+		if v := recover(); v == nil || !strings.Contains(v.(error).Error(), "provider is nil") {
+			t.Errorf("panic = %v", v)
+		}
+	}()
+	s.AddService("svc.cache", (*injectMailer)(nil), nil)
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+// RouteManifest contains the comment routes of the bundles, it is generated by
+// the "routegen" command, so the production binary does not need the bundle
+// sources.
+type RouteManifest struct {
+	Bundles  []string // the bundles which were scanned
+	Routes   []ManifestRoute
+	Timeouts map[string]string // the "@timeout" comments, the keys are the actions
+}
+
+// ManifestRoute is the route of the "@route" comment.
+type ManifestRoute struct {
+	Method     string
+	Pattern    string
+	Action     string // looks like "bundle.Controller.Action"
+	Controller func() interface{}
+}
+
+// UseManifest loads the comment routes from the manifest instead of scanning
+// DirBundle when the server starts, the "RegRoute" methods of the bundles which
+// the manifest covers are not called, because the controllers they add are
+// already in the manifest. The bundles which were not scanned, e.g. the bundles
+// added after the manifest was generated, are still read from DirBundle.
+//
+// Usage:
+//
+//	//go:generate go run gopkg.in/orivil/orivil.v2/routegen -dir bundle -o routes_gen.go
+//
+//	server := orivil.NewServer(":8080")
+//	server.UseManifest(RouteManifest)
+func (s *Server) UseManifest(m *RouteManifest) {
+
+	s.manifest = m
+}
+
+// manifestCovers checks whether or not the bundle routes are in the manifest.
+func (s *Server) manifestCovers(bundle string) bool {
+	if s.manifest == nil {
+		return false
+	}
+	for _, b := range s.manifest.Bundles {
+		if b == bundle {
+			return true
+		}
+	}
+	return false
+}
+
+// loadManifest adds the manifest routes and timeouts.
+func (s *Server) loadManifest() {
+	for _, r := range s.manifest.Routes {
+		s.addRoute(&route{
+			method:     r.Method,
+			pattern:    r.Pattern,
+			action:     r.Action,
+			controller: r.Controller,
+			source:     "route manifest",
+			comment:    true,
+		})
+	}
+	s.setTimeoutComments("route manifest", s.manifest.Timeouts)
+}
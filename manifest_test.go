@@ -0,0 +1,63 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type manifestController struct{}
+
+func TestLoadManifest(t *testing.T) {
+	s := newRouteServer(t)
+	s.routeTimeouts = make(map[string]time.Duration)
+	s.UseManifest(&RouteManifest{
+		Bundles: []string{"user"},
+		Routes: []ManifestRoute{
+			{Method: "GET", Pattern: "/users/:id", Action: "user.Controller.Show", Controller: func() interface{} { return new(manifestController) }},
+			{Method: "GET", Pattern: "/users/new", Action: "user.Controller.New", Controller: func() interface{} { return new(manifestController) }},
+		},
+		Timeouts: map[string]string{"user.Controller.Show": "5s", "user.Controller.New": "soon"},
+	})
+	s.loadManifest()
+
+	action, params, controller, _, ok := s.match("GET", "/users/5")
+	if !ok || action != "user.Controller.Show" || params["id"] != "5" {
+		t.Fatalf("match() = %q, %v, %v", action, params, ok)
+	}
+	if _, ok := controller().(*manifestController); !ok {
+		t.Errorf("controller() = %T", controller())
+	}
+	if action, _, _, _, _ := s.match("GET", "/users/new"); action != "user.Controller.New" {
+		t.Errorf("match(/users/new) = %q", action)
+	}
+	want := map[string]time.Duration{"user.Controller.Show": 5 * time.Second}
+	if !reflect.DeepEqual(s.routeTimeouts, want) {
+		t.Errorf("timeouts = %v, want %v", s.routeTimeouts, want)
+	}
+	if s.routePaths["user.Controller.Show"] != "/users/:id" {
+		t.Errorf("route paths = %v", s.routePaths)
+	}
+}
+
+func TestManifestCovers(t *testing.T) {
+	dir := writeBundle(t, map[string]string{"register.go": scanRegister, "controller.go": scanController})
+	s := newRouteServer(t)
+	s.UseManifest(&RouteManifest{Bundles: []string{"user"}})
+	s.loadRouteComments(filepath.Dir(dir))
+	if len(s.commentRoutes) != 0 || len(s.routePaths) != 0 || len(s.routeTimeouts) != 0 {
+		t.Errorf("the bundle of the manifest was scanned: %v, %v", s.routePaths, s.routeTimeouts)
+	}
+
+	s = newRouteServer(t)
+	s.UseManifest(&RouteManifest{Bundles: []string{"home"}})
+	s.loadRouteComments(filepath.Dir(dir))
+	if len(s.commentRoutes) != 6 {
+		t.Errorf("comment routes = %d, want 6", len(s.commentRoutes))
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// msgPackEncoder is a small MessagePack encoder for the response renderer,
+// struct fields are encoded as maps and named by the "json" tag if exist.
+type msgPackEncoder struct {
+	w   io.Writer
+	buf [9]byte
+}
+
+func newMsgPackEncoder(w io.Writer) *msgPackEncoder {
+
+	return &msgPackEncoder{w: w}
+}
+
+func (e *msgPackEncoder) encode(v interface{}) error {
+	if v == nil {
+		return e.write(0xc0)
+	}
+	return e.encodeValue(reflect.ValueOf(v))
+}
+
+func (e *msgPackEncoder) encodeValue(v reflect.Value) error {
+	if !v.IsValid() {
+		return e.write(0xc0)
+	}
+	// the nil values must be checked before the methods are called
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return e.write(0xc0)
+		}
+		if v.Kind() == reflect.Interface {
+			return e.encodeValue(v.Elem())
+		}
+	}
+	if v.Type() == reflect.TypeOf(time.Time{}) {
+		return e.encodeString(v.Interface().(time.Time).Format(time.RFC3339Nano))
+	}
+	if v.CanInterface() {
+		if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+			text, err := m.MarshalText()
+			if err != nil {
+				return err
+			}
+			return e.encodeString(string(text))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		return e.encodeValue(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return e.write(0xc3)
+		}
+		return e.write(0xc2)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return e.encodeUint(v.Uint())
+	case reflect.Float32:
+		binary.BigEndian.PutUint32(e.buf[1:], math.Float32bits(float32(v.Float())))
+		e.buf[0] = 0xca
+		return e.write(e.buf[:5]...)
+	case reflect.Float64:
+		binary.BigEndian.PutUint64(e.buf[1:], math.Float64bits(v.Float()))
+		e.buf[0] = 0xcb
+		return e.write(e.buf[:9]...)
+	case reflect.String:
+		return e.encodeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return e.write(0xc0)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return e.encodeBytes(v)
+		}
+		if err := e.encodeLen(v.Len(), 0x90, 0xdc, 0xdd); err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encodeValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if v.IsNil() {
+			return e.write(0xc0)
+		}
+		if err := e.encodeLen(v.Len(), 0x80, 0xde, 0xdf); err != nil {
+			return err
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encodeValue(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encodeValue(iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	}
+	return fmt.Errorf("msgpack: unsupported type: %v", v.Type())
+}
+
+func (e *msgPackEncoder) encodeStruct(v reflect.Value) error {
+	t := v.Type()
+	var names []string
+	var fields []reflect.Value
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		names = append(names, name)
+		fields = append(fields, v.Field(i))
+	}
+	if err := e.encodeLen(len(names), 0x80, 0xde, 0xdf); err != nil {
+		return err
+	}
+	for i, name := range names {
+		if err := e.encodeString(name); err != nil {
+			return err
+		}
+		if err := e.encodeValue(fields[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgPackEncoder) encodeInt(i int64) error {
+	switch {
+	case i >= 0:
+		return e.encodeUint(uint64(i))
+	case i >= -32:
+		return e.write(byte(i))
+	case i >= math.MinInt8:
+		return e.write(0xd0, byte(i))
+	case i >= math.MinInt16:
+		e.buf[0] = 0xd1
+		binary.BigEndian.PutUint16(e.buf[1:], uint16(i))
+		return e.write(e.buf[:3]...)
+	case i >= math.MinInt32:
+		e.buf[0] = 0xd2
+		binary.BigEndian.PutUint32(e.buf[1:], uint32(i))
+		return e.write(e.buf[:5]...)
+	}
+	e.buf[0] = 0xd3
+	binary.BigEndian.PutUint64(e.buf[1:], uint64(i))
+	return e.write(e.buf[:9]...)
+}
+
+func (e *msgPackEncoder) encodeUint(u uint64) error {
+	switch {
+	case u <= 0x7f:
+		return e.write(byte(u))
+	case u <= math.MaxUint8:
+		return e.write(0xcc, byte(u))
+	case u <= math.MaxUint16:
+		e.buf[0] = 0xcd
+		binary.BigEndian.PutUint16(e.buf[1:], uint16(u))
+		return e.write(e.buf[:3]...)
+	case u <= math.MaxUint32:
+		e.buf[0] = 0xce
+		binary.BigEndian.PutUint32(e.buf[1:], uint32(u))
+		return e.write(e.buf[:5]...)
+	}
+	e.buf[0] = 0xcf
+	binary.BigEndian.PutUint64(e.buf[1:], u)
+	return e.write(e.buf[:9]...)
+}
+
+func (e *msgPackEncoder) encodeString(s string) error {
+	l := len(s)
+	var err error
+	switch {
+	case l <= 31:
+		err = e.write(0xa0 | byte(l))
+	case l <= math.MaxUint8:
+		err = e.write(0xd9, byte(l))
+	default:
+		err = e.encodeLen(l, 0, 0xda, 0xdb)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(e.w, s)
+	return err
+}
+
+func (e *msgPackEncoder) encodeBytes(v reflect.Value) error {
+	l := v.Len()
+	var err error
+	if l <= math.MaxUint8 {
+		err = e.write(0xc4, byte(l))
+	} else {
+		err = e.encodeLen(l, 0, 0xc5, 0xc6)
+	}
+	if err != nil {
+		return err
+	}
+	b := make([]byte, l)
+	reflect.Copy(reflect.ValueOf(b), v)
+	_, err = e.w.Write(b)
+	return err
+}
+
+// encodeLen writes the length header, fix is the "fix" format prefix which
+// holds lengths less than 16, zero means no fix format.
+func (e *msgPackEncoder) encodeLen(l int, fix, code16, code32 byte) error {
+	switch {
+	case fix != 0 && l < 16:
+		return e.write(fix | byte(l))
+	case l <= math.MaxUint16:
+		e.buf[0] = code16
+		binary.BigEndian.PutUint16(e.buf[1:], uint16(l))
+		return e.write(e.buf[:3]...)
+	}
+	e.buf[0] = code32
+	binary.BigEndian.PutUint32(e.buf[1:], uint32(l))
+	return e.write(e.buf[:5]...)
+}
+
+func (e *msgPackEncoder) write(b ...byte) error {
+	_, err := e.w.Write(b)
+	return err
+}
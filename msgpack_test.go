@@ -0,0 +1,61 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"testing"
+)
+
+type textID int
+
+func (id *textID) MarshalText() ([]byte, error) {
+	if id == nil {
+		return nil, errors.New("MarshalText called on nil")
+	}
+	return []byte("id"), nil
+}
+
+func TestMsgPackEncode(t *testing.T) {
+	var nilID *textID
+	id := textID(1)
+	tests := []struct {
+		name string
+		v    interface{}
+		want []byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"true", true, []byte{0xc3}},
+		{"fixint", 5, []byte{0x05}},
+		{"negative fixint", -1, []byte{0xff}},
+		{"int8", -100, []byte{0xd0, 0x9c}},
+		{"uint16", 300, []byte{0xcd, 0x01, 0x2c}},
+		{"fixstr", "ab", []byte{0xa2, 'a', 'b'}},
+		{"bin", []byte{1, 2}, []byte{0xc4, 0x02, 1, 2}},
+		{"array", []int{1, 2}, []byte{0x92, 1, 2}},
+		{"map", map[string]int{"a": 1}, []byte{0x81, 0xa1, 'a', 1}},
+		{"struct", struct {
+			A int `json:"a"`
+			B int `json:"-"`
+			c int
+		}{A: 1}, []byte{0x81, 0xa1, 'a', 1}},
+		{"text marshaler", &id, []byte{0xa2, 'i', 'd'}},
+		{"nil text marshaler", nilID, []byte{0xc0}},
+		{"nil text marshaler in interface", []encoding.TextMarshaler{nilID}, []byte{0x91, 0xc0}},
+		{"nil text marshaler in map", map[string]interface{}{"a": nilID}, []byte{0x81, 0xa1, 'a', 0xc0}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := newMsgPackEncoder(&buf).encode(test.v); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.want) {
+			t.Errorf("%s: got % x, want % x", test.name, buf.Bytes(), test.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// media types of the built-in renderers
+const (
+	MediaJSON    = "application/json"
+	MediaXML     = "application/xml"
+	MediaMsgPack = "application/msgpack"
+	MediaYAML    = "application/x-yaml"
+	MediaText    = "text/plain"
+	MediaHTML    = "text/html"
+)
+
+// FormatKey is the query key for overriding the "Accept" header,
+// e.g. "/users?_format=xml".
+const FormatKey = "_format"
+
+// Renderer renders the response data as one media type.
+type Renderer interface {
+	Render(app *App, data interface{}) error
+}
+
+// RendererFunc is an adapter to allow the use of ordinary functions as renderers.
+type RendererFunc func(app *App, data interface{}) error
+
+func (f RendererFunc) Render(app *App, data interface{}) error {
+
+	return f(app, data)
+}
+
+// SetRenderer registers the renderer for the media type, the formats are the
+// short names which can be used by the "_format" query value.
+//
+// Usage:
+//
+//	s.SetRenderer("text/csv", csvRenderer, "csv")
+func (s *Server) SetRenderer(mediaType string, r Renderer, formats ...string) {
+	if _, ok := s.renderers[mediaType]; !ok {
+		s.mediaTypes = append(s.mediaTypes, mediaType)
+	}
+	s.renderers[mediaType] = r
+	for _, format := range formats {
+		s.formats[format] = mediaType
+	}
+}
+
+// Renderer returns the renderer registered for the media type.
+func (s *Server) Renderer(mediaType string) Renderer {
+
+	return s.renderers[mediaType]
+}
+
+func (s *Server) setDefaultRenderers() {
+	s.SetRenderer(MediaHTML, RendererFunc(renderView), "html")
+	s.SetRenderer(MediaJSON, RendererFunc(renderJSON), "json")
+	s.SetRenderer(MediaXML, RendererFunc(renderXML), "xml")
+	s.SetRenderer(MediaMsgPack, RendererFunc(renderMsgPack), "msgpack")
+	s.SetRenderer(MediaYAML, RendererFunc(renderYAML), "yaml", "yml")
+	s.SetRenderer(MediaText, RendererFunc(renderText), "txt", "text")
+}
+
+// MediaType returns the negotiated response media type, it reads the "_format"
+// query value first, then the "Accept" header. The HTML view renderer is only
+// offered if view pages were set.
+func (app *App) MediaType() string {
+	if app.mediaType == "" {
+		s := app.Server
+		def := MediaJSON
+		if app.viewPages != nil {
+			def = MediaHTML
+		}
+		if format := app.Request.URL.Query().Get(FormatKey); format != "" {
+			if t, ok := s.formats[format]; ok && (t != MediaHTML || app.viewPages != nil) {
+				app.mediaType = t
+				return t
+			}
+		}
+		offers := []string{def}
+		for _, t := range s.mediaTypes {
+			if t != def && (t != MediaHTML || app.viewPages != nil) {
+				offers = append(offers, t)
+			}
+		}
+		app.mediaType = Negotiate(app.Request.Header.Get("Accept"), offers, def)
+	}
+	return app.mediaType
+}
+
+// Render renders the data by the negotiated media type renderer.
+func (app *App) Render(data interface{}) {
+	app.rendered = true
+	mediaType := app.MediaType()
+	app.Response.Header().Add("Vary", "Accept")
+	err := app.Server.renderers[mediaType].Render(app, data)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Negotiate chooses the best offered media type for the "Accept" header value,
+// the order of offers is the server preference. If the header is empty or no
+// offer is acceptable, def will be returned.
+func Negotiate(accept string, offers []string, def string) string {
+	if accept == "" {
+		return def
+	}
+	specs := parseAccept(accept)
+	best, bestQ := def, 0.0
+	for _, offer := range offers {
+		q, level := 0.0, -1
+		for _, spec := range specs {
+			if l := spec.match(offer); l > level {
+				q, level = spec.q, l
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+type acceptSpec struct {
+	typ, sub string
+	q        float64
+}
+
+// match returns the specificity level if the spec matches the media type,
+// returns -1 if not match.
+func (s acceptSpec) match(mediaType string) int {
+	typ, sub := mediaType, ""
+	if idx := strings.Index(mediaType, "/"); idx > 0 {
+		typ, sub = mediaType[:idx], mediaType[idx+1:]
+	}
+	switch {
+	case s.typ == typ && s.sub == sub:
+		return 2
+	case s.typ == typ && s.sub == "*":
+		return 1
+	case s.typ == "*":
+		return 0
+	}
+	return -1
+}
+
+func parseAccept(accept string) (specs []acceptSpec) {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+		spec := acceptSpec{typ: mediaType, sub: "*", q: 1}
+		if idx := strings.Index(mediaType, "/"); idx > 0 {
+			spec.typ, spec.sub = mediaType[:idx], mediaType[idx+1:]
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					spec.q = q
+				}
+			}
+		}
+		specs = append(specs, spec)
+	}
+	return
+}
+
+func renderView(app *App, data interface{}) error {
+	app.Response.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	if m, ok := data.(map[string]interface{}); ok {
+		if _, exist := m[AssetsKey]; !exist {
+			m[AssetsKey] = app.Server.assets
+		}
+		if _, exist := m[URLKey]; !exist {
+			m[URLKey] = &URLBuilder{server: app.Server, request: app.Request}
+		}
+	}
+	return app.VContainer.Display(app.Response, data, app.viewPages...)
+}
+
+func renderJSON(app *App, data interface{}) error {
+	app.Response.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	return json.NewEncoder(app.Response).Encode(data)
+}
+
+func renderXML(app *App, data interface{}) error {
+	app.Response.Header().Set("Content-Type", "application/xml;charset=UTF-8")
+	if m, ok := data.(map[string]interface{}); ok {
+		data = xmlMap(m)
+	}
+	app.Response.Write([]byte(xml.Header))
+	return xml.NewEncoder(app.Response).Encode(data)
+}
+
+func renderMsgPack(app *App, data interface{}) error {
+	app.Response.Header().Set("Content-Type", MediaMsgPack)
+	return newMsgPackEncoder(app.Response).encode(data)
+}
+
+func renderYAML(app *App, data interface{}) error {
+	app.Response.Header().Set("Content-Type", "application/x-yaml;charset=UTF-8")
+	out, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = app.Response.Write(out)
+	return err
+}
+
+func renderText(app *App, data interface{}) error {
+	app.Response.Header().Set("Content-Type", "text/plain;charset=UTF-8")
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		_, err := fmt.Fprintln(app.Response, data)
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(app.Response, "%s: %v\n", key, m[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xmlMap encodes the view data map as "<response><key>value</key></response>",
+// because encoding/xml does not support maps.
+type xmlMap map[string]interface{}
+
+func (m xmlMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if start.Name.Local == "xmlMap" {
+		start.Name.Local = "response"
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		value := m[key]
+		if sub, ok := value.(map[string]interface{}); ok {
+			value = xmlMap(sub)
+		} else if sub, ok := value.(map[string]string); ok {
+			conv := make(xmlMap, len(sub))
+			for k, v := range sub {
+				conv[k] = v
+			}
+			value = conv
+		}
+		if err := e.EncodeElement(value, xml.StartElement{Name: xml.Name{Local: xmlName(key)}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// xmlName converts the map key to a valid XML element name, the invalid
+// characters are replaced by "_", and "_" is prefixed if the name does not
+// start with a letter or "_".
+func xmlName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case r == '-' || r == '.' || unicode.IsDigit(r):
+			if i == 0 {
+				b.WriteByte('_')
+			}
+		default:
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
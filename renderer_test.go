@@ -0,0 +1,64 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{MediaJSON, MediaXML, MediaHTML}
+	tests := []struct {
+		accept, want string
+	}{
+		{"", MediaJSON},
+		{"application/xml", MediaXML},
+		{"application/xml;q=0.5, application/json;q=0.9", MediaJSON},
+		{"application/json;q=0.1, text/*;q=0.8", MediaHTML},
+		{"text/html;q=0, */*;q=0.5", MediaJSON},
+		{"image/png", MediaJSON},
+		{"*/*", MediaJSON},
+		// the more specific spec wins even if it comes later
+		{"*/*;q=1, application/json;q=0.2, application/xml", MediaXML},
+	}
+	for _, test := range tests {
+		if got := Negotiate(test.accept, offers, MediaJSON); got != test.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestParseAccept(t *testing.T) {
+	specs := parseAccept("Text/HTML;level=1;q=0.7, application/*; q=0.3, bad;q=x, ,*/*")
+	want := []acceptSpec{
+		{"text", "html", 0.7},
+		{"application", "*", 0.3},
+		{"bad", "*", 1},
+		{"*", "*", 1},
+	}
+	if len(specs) != len(want) {
+		t.Fatalf("got %d specs, want %d: %v", len(specs), len(want), specs)
+	}
+	for i := range want {
+		if specs[i] != want[i] {
+			t.Errorf("spec %d = %+v, want %+v", i, specs[i], want[i])
+		}
+	}
+}
+
+func TestXMLMapKeys(t *testing.T) {
+	out, err := xml.Marshal(xmlMap{"first name": "a", "1st": "b", "ok": "c", "": "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<response><_>d</_><_1st>b</_1st><first_name>a</first_name><ok>c</ok></response>"
+	if string(out) != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+	if err := xml.Unmarshal(out, new(struct{})); err != nil {
+		t.Errorf("malformed XML: %v", err)
+	}
+}
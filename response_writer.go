@@ -0,0 +1,222 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+var ErrHijacked = errors.New("response writer was hijacked")
+
+// ResponseWriter is the response writer of App, it records the status code and
+// the size of the response, so middleware can check the response in
+// "Terminate". It also implements http.Hijacker and http.Pusher, they return
+// errors if the underlying writer does not support them.
+type ResponseWriter interface {
+	http.ResponseWriter
+	http.Flusher
+	http.Hijacker
+	http.Pusher
+
+	// Status returns the status code which was sent, 0 if not sent.
+	Status() int
+
+	// Written reports whether or not the header was sent.
+	Written() bool
+
+	// Size returns the bytes of the body which were written.
+	Size() int64
+}
+
+// responseWriter is safe for concurrent use, so the timeout response can be
+// written by the timer goroutine. The headers are kept in a separate map and
+// copied to the underlying writer when the header is sent.
+type responseWriter struct {
+	w        http.ResponseWriter
+	h        http.Header
+	mu       sync.Mutex
+	status   int
+	size     int64
+	hijacked bool
+	timedOut bool
+	// the timeout response was written
+	timeoutWritten bool
+	finished       bool
+	// discard the body of "HEAD" requests
+	discardBody bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	if rw, ok := w.(*responseWriter); ok {
+		return rw
+	}
+	h := make(http.Header, len(w.Header()))
+	for k, v := range w.Header() {
+		h[k] = v
+	}
+	return &responseWriter{w: w, h: h}
+}
+
+func (rw *responseWriter) Header() http.Header {
+
+	return rw.h
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.writeHeader(code)
+}
+
+func (rw *responseWriter) writeHeader(code int) {
+	if rw.timedOut || rw.hijacked || rw.status != 0 {
+		return
+	}
+	dst := rw.w.Header()
+	for k, v := range rw.h {
+		dst[k] = v
+	}
+	// the informational responses are sent before the final response, e.g.
+	// "103 Early Hints", they are not recorded as the status
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		rw.w.WriteHeader(code)
+		return
+	}
+	rw.status = code
+	rw.w.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if rw.hijacked {
+		return 0, ErrHijacked
+	}
+	rw.writeHeader(http.StatusOK)
+	if rw.discardBody {
+		return len(b), nil
+	}
+	n, err := rw.w.Write(b)
+	rw.size += int64(n)
+	return n, err
+}
+
+func (rw *responseWriter) Flush() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.timedOut || rw.hijacked {
+		return
+	}
+	rw.writeHeader(http.StatusOK)
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	h, ok := rw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, buf, err := h.Hijack()
+	if err == nil {
+		rw.hijacked = true
+		if rw.status == 0 {
+			rw.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, buf, err
+}
+
+func (rw *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := rw.w.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+func (rw *responseWriter) Status() int {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.status
+}
+
+func (rw *responseWriter) Written() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.status != 0
+}
+
+func (rw *responseWriter) Size() int64 {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.size
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+
+	return rw.w
+}
+
+// setHeader sets the header to both the action headers and the underlying
+// headers, so the header is sent with the timeout response too.
+func (rw *responseWriter) setHeader(key, value string) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.h.Set(key, value)
+	rw.w.Header().Set(key, value)
+}
+
+// timeout stops the action writes, and writes the timeout response if nothing
+// was written, returns whether or not the timeout response was written.
+func (rw *responseWriter) timeout(write func(w http.ResponseWriter)) bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.hijacked || rw.finished {
+		return false
+	}
+	rw.timedOut = true
+	if rw.status != 0 {
+		return false
+	}
+	rw.status = http.StatusServiceUnavailable
+	rw.timeoutWritten = true
+	write(rw.w)
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return true
+}
+
+func (rw *responseWriter) timeoutSent() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.timeoutWritten
+}
+
+// finish marks the handler as returned, the timeout response is no longer
+// written after that.
+func (rw *responseWriter) finish() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.finished = true
+}
+
+// Writer returns the response writer which records the status code and the
+// response size.
+func (app *App) Writer() ResponseWriter {
+
+	return app.writer
+}
@@ -0,0 +1,46 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// codesWriter records the status codes which were sent.
+type codesWriter struct {
+	*httptest.ResponseRecorder
+	codes []int
+	links []string
+}
+
+func (w *codesWriter) WriteHeader(code int) {
+	w.codes = append(w.codes, code)
+	w.links = append(w.links, w.Header().Get("Link"))
+}
+
+func TestResponseWriterInformational(t *testing.T) {
+	w := &codesWriter{ResponseRecorder: httptest.NewRecorder()}
+	rw := newResponseWriter(w)
+	rw.Header().Set("Link", "</app.css>; rel=preload")
+	rw.WriteHeader(http.StatusEarlyHints)
+	if rw.Written() || rw.Status() != 0 {
+		t.Errorf("the informational response was recorded as status %d", rw.Status())
+	}
+	rw.Header().Set("Link", "</app.js>; rel=preload")
+	rw.WriteHeader(http.StatusCreated)
+	rw.WriteHeader(http.StatusOK)
+	if rw.Status() != http.StatusCreated {
+		t.Errorf("Status() = %d, want %d", rw.Status(), http.StatusCreated)
+	}
+	if want := []int{http.StatusEarlyHints, http.StatusCreated}; !reflect.DeepEqual(w.codes, want) {
+		t.Errorf("sent codes = %v, want %v", w.codes, want)
+	}
+	if want := []string{"</app.css>; rel=preload", "</app.js>; rel=preload"}; !reflect.DeepEqual(w.links, want) {
+		t.Errorf("sent links = %v, want %v", w.links, want)
+	}
+}
@@ -0,0 +1,468 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"fmt"
+	"gopkg.in/orivil/router.v0"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// route is the route registered by code, or the "@route" comment route.
+type route struct {
+	method     string
+	pattern    string
+	segments   []string
+	action     string // looks like "bundle.Controller.Action"
+	controller func() interface{}
+	middles    []interface{}
+	fn         reflect.Value // the method expression
+	source     string        // the file and line which registered the route
+	comment    bool          // whether or not it is the "@route" comment route
+}
+
+// RouteGroup registers the routes with the same path prefix and middleware.
+type RouteGroup struct {
+	server  *Server
+	prefix  string
+	middles []interface{}
+}
+
+// Group creates the route group, the middleware could be the middleware
+// service names or the middleware instances(RequestHandler, TerminateHandler
+// or func(*App)), they are called after the middleware configured by
+// middle.Bag, in the order they were added.
+//
+// Usage:
+//
+//	api := s.Group("/api", "api.Auth")
+//	api.GET("/users/:id", (*user.Controller).Show)
+//	api.POST("/users", (*user.Controller).Create)
+//
+//	admin := api.Group("/admin", orivil.Timeout(time.Minute))
+//	admin.DELETE("/users/:id", (*user.Controller).Delete)
+func (s *Server) Group(prefix string, middles ...interface{}) *RouteGroup {
+
+	return &RouteGroup{server: s, prefix: prefix, middles: middles}
+}
+
+// Group creates the sub group, the prefix and the middleware are appended to
+// the group's.
+func (g *RouteGroup) Group(prefix string, middles ...interface{}) *RouteGroup {
+	return &RouteGroup{
+		server:  g.server,
+		prefix:  joinRoutePath(g.prefix, prefix),
+		middles: append(append([]interface{}{}, g.middles...), middles...),
+	}
+}
+
+// Use adds the middleware to the routes which will be registered by the group.
+func (g *RouteGroup) Use(middles ...interface{}) {
+
+	g.middles = append(g.middles, middles...)
+}
+
+func (g *RouteGroup) GET(pattern string, action interface{}) {
+
+	g.Handle("GET", pattern, action)
+}
+
+func (g *RouteGroup) POST(pattern string, action interface{}) {
+
+	g.Handle("POST", pattern, action)
+}
+
+func (g *RouteGroup) PUT(pattern string, action interface{}) {
+
+	g.Handle("PUT", pattern, action)
+}
+
+func (g *RouteGroup) PATCH(pattern string, action interface{}) {
+
+	g.Handle("PATCH", pattern, action)
+}
+
+func (g *RouteGroup) DELETE(pattern string, action interface{}) {
+
+	g.Handle("DELETE", pattern, action)
+}
+
+func (g *RouteGroup) OPTIONS(pattern string, action interface{}) {
+
+	g.Handle("OPTIONS", pattern, action)
+}
+
+// Handle registers the route, the action is a controller method expression,
+// e.g. (*UserController).Show. The pattern segments ":name" match one path
+// segment, "*name" matches the rest of the path. It panics if the action is
+// not a controller method, its signature is not supported, or the server was
+// started.
+//
+// The action could declare the route params, the bound request struct, the
+// context and the services as arguments, and return the value to render:
+//
+//	s.GET("/users/:id", (*user.Controller).Show)
+//
+//	func (c *Controller) Show(ctx context.Context, id int, users *UserStore) (*User, error) {
+//		return users.Find(ctx, id)
+//	}
+func (g *RouteGroup) Handle(method, pattern string, action interface{}) {
+	rt := &route{
+		method:  strings.ToUpper(method),
+		pattern: joinRoutePath(g.prefix, pattern),
+		middles: append([]interface{}{}, g.middles...),
+		fn:      reflect.ValueOf(action),
+	}
+	if _, file, line, ok := runtime.Caller(1); ok {
+		if strings.HasSuffix(file, "/route.go") {
+			// called by the Server or RouteGroup shortcuts
+			_, file, line, _ = runtime.Caller(2)
+		}
+		rt.source = fmt.Sprintf("%s:%d", file, line)
+	}
+	typ, name, err := controllerMethod(rt.fn)
+	if err != nil {
+		panic(fmt.Errorf("route %s %s: %v", rt.method, rt.pattern, err))
+	}
+	rt.action = filepath.Base(typ.PkgPath()) + "." + typ.Name() + "." + name
+	if _, err := g.server.checkAction(rt.fn.Type(), rt.pattern, false); err != nil {
+		panic(fmt.Errorf("route %s %s(%s): %v", rt.method, rt.pattern, rt.action, err))
+	}
+	rt.controller = func() interface{} {
+		return reflect.New(typ).Interface()
+	}
+	g.server.addRoute(rt)
+}
+
+// addRoute adds the route to the router, it panics if the server was started.
+func (s *Server) addRoute(rt *route) {
+	if s.booted {
+		panic(fmt.Errorf("route %s %s(%s): the routes must be added before the server starts", rt.method, rt.pattern, rt.action))
+	}
+	rt.segments = strings.Split(strings.Trim(rt.pattern, "/"), "/")
+	s.routes = append(s.routes, rt)
+	if s.trees[rt.method] == nil {
+		s.trees[rt.method] = &routeNode{}
+	}
+	s.trees[rt.method].insert(rt)
+
+	// the first route of the action is used for building URLs
+	if _, ok := s.routePaths[rt.action]; !ok {
+		s.SetRoutePath(rt.action, rt.pattern)
+	}
+}
+
+// GET registers the route to the root group, see RouteGroup.Handle.
+//
+// Usage:
+//
+//	s.GET("/users/:id", (*user.Controller).Show)
+func (s *Server) GET(pattern string, action interface{}) {
+
+	s.Group("").Handle("GET", pattern, action)
+}
+
+func (s *Server) POST(pattern string, action interface{}) {
+
+	s.Group("").Handle("POST", pattern, action)
+}
+
+func (s *Server) PUT(pattern string, action interface{}) {
+
+	s.Group("").Handle("PUT", pattern, action)
+}
+
+func (s *Server) PATCH(pattern string, action interface{}) {
+
+	s.Group("").Handle("PATCH", pattern, action)
+}
+
+func (s *Server) DELETE(pattern string, action interface{}) {
+
+	s.Group("").Handle("DELETE", pattern, action)
+}
+
+func (s *Server) OPTIONS(pattern string, action interface{}) {
+
+	s.Group("").Handle("OPTIONS", pattern, action)
+}
+
+// Handle registers the route to the root group, see RouteGroup.Handle.
+func (s *Server) Handle(method, pattern string, action interface{}) {
+
+	s.Group("").Handle(method, pattern, action)
+}
+
+// controllerMethod checks the method expression and returns the controller
+// struct type and the method name.
+func controllerMethod(fn reflect.Value) (reflect.Type, string, error) {
+	if !fn.IsValid() {
+		return nil, "", fmt.Errorf("the action must be a method expression like (*Controller).Action, got nil")
+	}
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return nil, "", fmt.Errorf("the action must be a method expression like (*Controller).Action, got %v", fn.Type())
+	}
+	ft := fn.Type()
+	if ft.NumIn() < 1 || ft.In(0).Kind() != reflect.Ptr || ft.In(0).Elem().Kind() != reflect.Struct {
+		return nil, "", fmt.Errorf("the action must be a method expression like (*Controller).Action, got %v", ft)
+	}
+	typ := ft.In(0).Elem()
+	for i := 0; i < ft.In(0).NumMethod(); i++ {
+		m := ft.In(0).Method(i)
+		if m.Func.Type() == ft && m.Func.Pointer() == fn.Pointer() {
+			return typ, m.Name, nil
+		}
+	}
+	return nil, "", fmt.Errorf("%v is not a method of %v", ft, ft.In(0))
+}
+
+// joinRoutePath joins the prefix and the pattern, the trailing slash of the
+// pattern is kept.
+func joinRoutePath(prefix, pattern string) string {
+	if prefix == "" {
+		return pattern
+	}
+	p := path.Join("/", prefix, pattern)
+	if strings.HasSuffix(pattern, "/") && p != "/" {
+		p += "/"
+	}
+	return p
+}
+
+// routeNode is the node of the route tree, each node is a path segment.
+type routeNode struct {
+	static   map[string]*routeNode
+	param    *routeNode // the ":name" segment
+	catchAll *route     // the "*name" segment
+	route    *route     // the route which ends at the node
+}
+
+// insert adds the route to the tree, the first route of the same segments is
+// kept, the later one is reported by checkRouteConflicts.
+func (n *routeNode) insert(rt *route) {
+	for _, seg := range rt.segments {
+		switch {
+		case strings.HasPrefix(seg, "*"):
+			if n.catchAll == nil {
+				n.catchAll = rt
+			}
+			return
+		case strings.HasPrefix(seg, ":"):
+			if n.param == nil {
+				n.param = &routeNode{}
+			}
+			n = n.param
+		default:
+			if n.static == nil {
+				n.static = make(map[string]*routeNode, 1)
+			}
+			child, ok := n.static[seg]
+			if !ok {
+				child = &routeNode{}
+				n.static[seg] = child
+			}
+			n = child
+		}
+	}
+	if n.route == nil {
+		n.route = rt
+	}
+}
+
+// lookup matches the path segment which starts at i, the path was trimmed by
+// "/", i is greater than the length of the path if all segments were matched.
+// The static segments have priority over the ":name" segments, the ":name"
+// segments have priority over "*name", the other branches are tried if the
+// preferred one does not match the rest of the path.
+func (n *routeNode) lookup(p string, i int) *route {
+	if i > len(p) {
+		if n.route != nil {
+			return n.route
+		}
+		return n.catchAll
+	}
+	j := strings.IndexByte(p[i:], '/')
+	if j < 0 {
+		j = len(p)
+	} else {
+		j += i
+	}
+	seg := p[i:j]
+	if child, ok := n.static[seg]; ok {
+		if rt := child.lookup(p, j+1); rt != nil {
+			return rt
+		}
+	}
+	if n.param != nil && seg != "" {
+		if rt := n.param.lookup(p, j+1); rt != nil {
+			return rt
+		}
+	}
+	return n.catchAll
+}
+
+// params returns the route params of the trimmed path which matched the route.
+func (rt *route) params(p string) router.Param {
+	var params router.Param
+	i := 0
+	for _, seg := range rt.segments {
+		j := len(p)
+		if i < len(p) {
+			if k := strings.IndexByte(p[i:], '/'); k >= 0 {
+				j = i + k
+			}
+		}
+		switch {
+		case strings.HasPrefix(seg, "*"):
+			if params == nil {
+				params = make(router.Param, 1)
+			}
+			if i < len(p) {
+				params[seg[1:]] = p[i:]
+			} else {
+				params[seg[1:]] = ""
+			}
+			return params
+		case strings.HasPrefix(seg, ":"):
+			if params == nil {
+				params = make(router.Param, 2)
+			}
+			params[strings.TrimLeft(seg, ":")] = p[i:j]
+		}
+		i = j + 1
+	}
+	return params
+}
+
+// match matches the route, the code routes and the comment routes are matched
+// by the same tree, the router only keeps the controllers.
+func (s *Server) match(method, urlPath string) (action string, params router.Param, controller func() interface{}, middles []interface{}, ok bool) {
+	if rt := s.matchRoute(method, urlPath); rt != nil {
+		return rt.action, rt.params(strings.Trim(urlPath, "/")), rt.controller, rt.middles, true
+	}
+	return
+}
+
+// matchRoute matches the route of the tree.
+func (s *Server) matchRoute(method, urlPath string) *route {
+	root := s.trees[method]
+	if root == nil {
+		return nil
+	}
+	return root.lookup(strings.Trim(urlPath, "/"), 0)
+}
+
+// hasRoute checks whether or not the method has any route matched the path.
+func (s *Server) hasRoute(method, urlPath string) bool {
+
+	return s.matchRoute(method, urlPath) != nil
+}
+
+// allActions returns the controllers and the actions of the comment routes and
+// the code routes, the keys of the providers look like "bundle.Controller".
+func (s *Server) allActions() (actions map[string]map[string][]string, providers map[string]func() interface{}) {
+	actions = make(map[string]map[string][]string)
+	providers = make(map[string]func() interface{})
+	add := func(bundle, controller, action string) {
+		if actions[bundle] == nil {
+			actions[bundle] = make(map[string][]string)
+		}
+		for _, a := range actions[bundle][controller] {
+			if a == action {
+				return
+			}
+		}
+		actions[bundle][controller] = append(actions[bundle][controller], action)
+	}
+	for bundle, controllers := range s.RContainer.GetActions() {
+		for controller, as := range controllers {
+			for _, action := range as {
+				add(bundle, controller, action)
+			}
+		}
+	}
+	for name, provider := range s.RContainer.GetControllers() {
+		providers[name] = provider
+	}
+	for _, rt := range s.routes {
+		name, action := splitAction(rt.action)
+		bundle, controller := splitAction(name)
+		add(bundle, controller, action)
+		if _, ok := providers[name]; !ok {
+			providers[name] = rt.controller
+		}
+	}
+	return
+}
+
+// splitAction splits "bundle.Controller.Action" to "bundle.Controller" and
+// "Action".
+func splitAction(action string) (string, string) {
+	idx := strings.LastIndex(action, ".")
+	return action[:idx], action[idx+1:]
+}
+
+// addCommentRoutes adds the "@route" comment routes which were read from the
+// bundle sources, the controllers are added by the "RegRoute" methods, so the
+// comment routes and the code routes are matched by the same tree. The routes
+// of the controllers which were not added are skipped, they have no provider.
+func (s *Server) addCommentRoutes() {
+	providers := s.RContainer.GetControllers()
+	for _, r := range s.commentRoutes {
+		name, _ := splitAction(r.Action)
+		provider, ok := providers[name]
+		if !ok {
+			continue
+		}
+		s.addRoute(&route{
+			method:     r.Method,
+			pattern:    r.Pattern,
+			action:     r.Action,
+			controller: provider,
+			source:     r.File,
+			comment:    true,
+		})
+	}
+}
+
+// checkRouteConflicts reports the routes which have the same method and the
+// same segments, except the comment routes of the same action, the param
+// names do not matter. It panics if any conflict was found.
+func (s *Server) checkRouteConflicts() {
+	var conflicts []string
+	seen := make(map[string]*route)
+	for _, rt := range s.routes {
+		var key []string
+		for _, seg := range rt.segments {
+			switch {
+			case strings.HasPrefix(seg, ":"):
+				seg = ":"
+			case strings.HasPrefix(seg, "*"):
+				seg = "*"
+			}
+			key = append(key, seg)
+		}
+		k := rt.method + " /" + strings.Join(key, "/")
+		prev, ok := seen[k]
+		if !ok {
+			seen[k] = rt
+			continue
+		}
+		if prev.comment && rt.comment && prev.action == rt.action {
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s %s(%s, %s) conflicts with %s %s(%s, %s)",
+			rt.method, rt.pattern, rt.action, rt.source, prev.method, prev.pattern, prev.action, prev.source))
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		panic(fmt.Errorf("route conflicts:\n\t%s", strings.Join(conflicts, "\n\t")))
+	}
+}
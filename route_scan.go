@@ -0,0 +1,297 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CommentRoute is the "@route" comment route of the bundle action, the pattern
+// is joined with the controller prefix.
+type CommentRoute struct {
+	Method     string // upper case, e.g. "GET"
+	Pattern    string // e.g. "/users/:id"
+	Action     string // "bundle.Controller.Action"
+	Controller string // the controller type name
+	File       string
+}
+
+// RouteSource is the comment routes of the bundle sources, see ScanRoutes.
+type RouteSource struct {
+	Package  string // the package name, empty if the directory has no Go file
+	Routes   []CommentRoute
+	Timeouts map[string]string // the "@timeout" comments, the keys are the actions
+	URLRefs  []URLRef
+}
+
+// URLRef is the action literal passed to URL, AbsURL or RedirectTo in the bundle
+// sources, e.g. app.RedirectTo("user.Controller.Show", "id", id).
+type URLRef struct {
+	Action string
+	Pos    string // "file:line:column"
+}
+
+// ScanRoutes reads the "@route" and "@timeout" comments and the URL references
+// of the bundle directory, the bundle name is the directory name. The controllers are read from the
+// "c.Add(prefix, provider)" calls in the "RegRoute" method, the prefix must be
+// a string literal and the provider must return "new(Controller)" or
+// "&Controller{}", otherwise an error is returned, because the routes of the
+// controller could not be known. The routes of the actions which have no
+// controller are skipped.
+//
+// Usage:
+//
+//	src, err := orivil.ScanRoutes("bundle/user")
+func ScanRoutes(dir string) (*RouteSource, error) {
+	bundle := filepath.Base(dir)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	sort.Strings(files)
+	src := &RouteSource{Timeouts: make(map[string]string)}
+	fset := token.NewFileSet()
+	var parsed []*ast.File
+	var names []string
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, f)
+		names = append(names, file)
+		src.Package = f.Name.Name
+	}
+
+	prefixes := make(map[string][]string)
+	for i, f := range parsed {
+		if err := controllerPrefixes(fset, f, prefixes); err != nil {
+			return nil, fmt.Errorf("%s: %v", names[i], err)
+		}
+	}
+
+	for i, f := range parsed {
+		src.URLRefs = append(src.URLRefs, urlRefs(fset, f)...)
+		for _, decl := range f.Decls {
+			method, ok := decl.(*ast.FuncDecl)
+			if !ok || method.Recv == nil || method.Doc == nil || len(method.Recv.List) == 0 {
+				continue
+			}
+			controller := receiverName(method.Recv.List[0].Type)
+			action := bundle + "." + controller + "." + method.Name.Name
+			for _, c := range method.Doc.List {
+				text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+				if value, ok := commentTag(text, "@timeout"); ok {
+					src.Timeouts[action] = value
+				}
+				value, ok := commentTag(text, "@route")
+				if !ok || len(prefixes[controller]) == 0 {
+					continue
+				}
+				if !method.Name.IsExported() {
+					return nil, fmt.Errorf("%s: the action %s is not exported", names[i], action)
+				}
+				methods, pattern := splitMethods(value)
+				for _, prefix := range prefixes[controller] {
+					prefixMethods, prefixPath := splitMethods(prefix)
+					ms := methods
+					if len(ms) == 0 {
+						ms = prefixMethods
+					}
+					if len(ms) == 0 {
+						ms = []string{"GET"}
+					}
+					for _, m := range ms {
+						src.Routes = append(src.Routes, CommentRoute{
+							Method:     m,
+							Pattern:    joinPrefix(prefixPath, pattern),
+							Action:     action,
+							Controller: controller,
+							File:       names[i],
+						})
+					}
+				}
+			}
+		}
+	}
+	return src, nil
+}
+
+// the methods which take the action as the first argument
+var urlMethods = map[string]bool{"URL": true, "AbsURL": true, "RedirectTo": true}
+
+// urlRefs returns the action literals passed to the URL methods, the calls are
+// not told from other methods without the types, so only the literals look like
+// "bundle.Controller.Action" are returned.
+func urlRefs(fset *token.FileSet, f *ast.File) (refs []URLRef) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || !urlMethods[sel.Sel.Name] {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		action, err := strconv.Unquote(lit.Value)
+		if err != nil || !isActionName(action) {
+			return true
+		}
+		refs = append(refs, URLRef{Action: action, Pos: fset.Position(lit.Pos()).String()})
+		return true
+	})
+	return
+}
+
+// isActionName checks whether or not the name looks like "bundle.Controller.Action".
+func isActionName(name string) bool {
+	parts := strings.Split(name, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	for _, p := range parts {
+		if !token.IsIdentifier(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// controllerPrefixes adds the route prefixes of the controller types, which are
+// read from the "c.Add(prefix, provider)" calls in the "RegRoute" method.
+func controllerPrefixes(fset *token.FileSet, f *ast.File, prefixes map[string][]string) (err error) {
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || fn.Name.Name != "RegRoute" || fn.Body == nil {
+			continue
+		}
+		params := fn.Type.Params.List
+		if len(params) == 0 || len(params[0].Names) == 0 {
+			continue
+		}
+		container := params[0].Names[0].Name
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			if err != nil {
+				return false
+			}
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != "Add" {
+				return true
+			}
+			if id, ok := sel.X.(*ast.Ident); !ok || id.Name != container {
+				return true
+			}
+			var prefix, typ string
+			literal := false
+			if len(call.Args) == 2 {
+				if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					prefix, _ = strconv.Unquote(lit.Value)
+					literal = true
+				}
+				if provider, ok := call.Args[1].(*ast.FuncLit); ok {
+					typ = providedType(provider)
+				}
+			}
+			if !literal || typ == "" {
+				err = fmt.Errorf("line %d: can not read the controller of %s.Add, the prefix must be a string "+
+					"literal and the provider must return new(Controller) or &Controller{}", fset.Position(call.Pos()).Line, container)
+				return false
+			}
+			prefixes[typ] = append(prefixes[typ], prefix)
+			return false
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// providedType returns the controller type name of the provider which returns
+// "new(Controller)" or "&Controller{}".
+func providedType(provider *ast.FuncLit) (typ string) {
+	ast.Inspect(provider.Body, func(n ast.Node) bool {
+		ret, ok := n.(*ast.ReturnStmt)
+		if !ok || len(ret.Results) != 1 {
+			return true
+		}
+		switch r := ret.Results[0].(type) {
+		case *ast.CallExpr:
+			if fun, ok := r.Fun.(*ast.Ident); ok && fun.Name == "new" && len(r.Args) == 1 {
+				if id, ok := r.Args[0].(*ast.Ident); ok {
+					typ = id.Name
+				}
+			}
+		case *ast.UnaryExpr:
+			if lit, ok := r.X.(*ast.CompositeLit); ok && r.Op == token.AND {
+				if id, ok := lit.Type.(*ast.Ident); ok {
+					typ = id.Name
+				}
+			}
+		}
+		return false
+	})
+	return
+}
+
+// commentTag returns the value of the comment text like "@route {get}/users".
+func commentTag(text, tag string) (string, bool) {
+	rest, ok := strings.CutPrefix(text, tag)
+	if !ok || rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// splitMethods splits "{get|post}/users" to the upper case methods and the path.
+func splitMethods(value string) (methods []string, p string) {
+	if strings.HasPrefix(value, "{") {
+		if idx := strings.Index(value, "}"); idx > 0 {
+			for _, m := range strings.FieldsFunc(value[1:idx], func(r rune) bool {
+				return r == '|' || r == ',' || r == ' '
+			}) {
+				methods = append(methods, strings.ToUpper(m))
+			}
+			value = value[idx+1:]
+		}
+	}
+	return methods, value
+}
+
+// joinPrefix joins the controller prefix and the pattern, the trailing slash of
+// the pattern is kept.
+func joinPrefix(prefix, pattern string) string {
+	p := path.Join("/", prefix, pattern)
+	if strings.HasSuffix(pattern, "/") && p != "/" {
+		p += "/"
+	}
+	return p
+}
+
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const scanRegister = `package user
+
+import "gopkg.in/orivil/router.v0"
+
+type Register struct{}
+
+func (*Register) RegRoute(c *router.Container) {
+	c.Add("{get}/admin", func() interface{} {
+		return new(Controller)
+	})
+	c.Add("/api/", func() interface{} {
+		return &Controller{}
+	})
+}
+`
+
+const scanController = `package user
+
+type Controller struct{}
+
+// @route /users/:id
+// @timeout 5s
+func (c *Controller) Show() {}
+
+// @route {post|put}/users/
+func (c *Controller) Save() {}
+
+// @route /other
+func (o *Other) Index() {}
+`
+
+// writeBundle writes the files of the bundle "user" under a temporary directory,
+// returns the bundle directory.
+func writeBundle(t *testing.T, files map[string]string) string {
+	dir := filepath.Join(t.TempDir(), "user")
+	os.MkdirAll(dir, 0755)
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestScanRoutes(t *testing.T) {
+	dir := writeBundle(t, map[string]string{"register.go": scanRegister, "controller.go": scanController})
+	src, err := ScanRoutes(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range src.Routes {
+		got = append(got, r.Method+" "+r.Pattern+" "+r.Action)
+	}
+	want := []string{
+		"GET /admin/users/:id user.Controller.Show",
+		"GET /api/users/:id user.Controller.Show",
+		"POST /admin/users/ user.Controller.Save",
+		"PUT /admin/users/ user.Controller.Save",
+		"POST /api/users/ user.Controller.Save",
+		"PUT /api/users/ user.Controller.Save",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("routes = %q, want %q", got, want)
+	}
+	if src.Package != "user" || src.Timeouts["user.Controller.Show"] != "5s" {
+		t.Errorf("package = %q, timeouts = %v", src.Package, src.Timeouts)
+	}
+}
+
+func TestScanRoutesUnreadableAdd(t *testing.T) {
+	for _, add := range []string{
+		`c.Add(prefix, func() interface{} { return new(Controller) })`,
+		`c.Add("/", newController)`,
+		`c.Add("/", func() interface{} { return newController() })`,
+	} {
+		register := strings.Replace(scanRegister, `c.Add("/api/", func() interface{} {
+		return &Controller{}
+	})`, add, 1)
+		dir := writeBundle(t, map[string]string{"register.go": register, "controller.go": scanController})
+		if _, err := ScanRoutes(dir); err == nil || !strings.Contains(err.Error(), "can not read the controller") {
+			t.Errorf("ScanRoutes(%s) error = %v", add, err)
+		}
+	}
+}
+
+func TestLoadRouteComments(t *testing.T) {
+	dir := writeBundle(t, map[string]string{"register.go": scanRegister, "controller.go": scanController})
+	s := &Server{routePaths: make(map[string]string), routeTimeouts: make(map[string]time.Duration)}
+	s.loadRouteComments(filepath.Dir(dir))
+	want := map[string]string{
+		"user.Controller.Show": "/admin/users/:id",
+		"user.Controller.Save": "/admin/users/",
+	}
+	if !reflect.DeepEqual(s.routePaths, want) {
+		t.Errorf("route paths = %v, want %v", s.routePaths, want)
+	}
+	timeouts := map[string]time.Duration{"user.Controller.Show": 5 * time.Second}
+	if !reflect.DeepEqual(s.routeTimeouts, timeouts) {
+		t.Errorf("timeouts = %v, want %v", s.routeTimeouts, timeouts)
+	}
+}
+
+func TestLoadRouteCommentsError(t *testing.T) {
+	register := strings.Replace(scanRegister, `"/api/"`, "prefix", 1)
+	dir := writeBundle(t, map[string]string{"register.go": register, "controller.go": scanController})
+	s := &Server{routePaths: make(map[string]string), routeTimeouts: make(map[string]time.Duration)}
+	defer func() {
+		if v := recover(); v == nil || !strings.Contains(v.(error).Error(), "can not read the controller") {
+			t.Errorf("panic = %v", v)
+		}
+		if len(s.commentRoutes) != 0 {
+			t.Errorf("comment routes = %v", s.commentRoutes)
+		}
+	}()
+	s.loadRouteComments(filepath.Dir(dir))
+}
+
+const scanLinks = `package user
+
+func links(app *orivil.App, s *orivil.Server, action string) {
+	app.RedirectTo("user.Controller.Show", "id", 1)
+	_ = app.AbsURL("home.Controller.Index")
+	_ = s.URL(` + "`user.Controller.Missing`" + `)
+	_ = app.URL(action)
+	_ = app.URL("/users")
+	_ = strings.Replace("a.b.c", "", "", 1)
+	_ = cache.Get("user.Controller.Show")
+}
+`
+
+func TestScanRoutesURLRefs(t *testing.T) {
+	dir := writeBundle(t, map[string]string{"register.go": scanRegister, "links.go": scanLinks})
+	src, err := ScanRoutes(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ref := range src.URLRefs {
+		got = append(got, filepath.Base(ref.Pos)+" "+ref.Action)
+	}
+	want := []string{
+		"links.go:4:17 user.Controller.Show",
+		"links.go:5:17 home.Controller.Index",
+		"links.go:6:12 user.Controller.Missing",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("URL refs = %q, want %q", got, want)
+	}
+}
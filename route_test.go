@@ -0,0 +1,241 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"gopkg.in/orivil/middle.v0"
+	"gopkg.in/orivil/router.v0"
+	"gopkg.in/orivil/service.v0"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRouteServer(t testing.TB) *Server {
+	return &Server{
+		RContainer:    router.NewContainer(t.TempDir(), func(string) bool { return true }),
+		routePaths:    make(map[string]string),
+		routeTimeouts: make(map[string]time.Duration),
+		trees:         make(map[string]*routeNode),
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	s := newRouteServer(t)
+	s.addRoute(&route{method: "GET", pattern: "/", action: "home.Controller.Index"})
+	s.addRoute(&route{method: "GET", pattern: "/users/:id", action: "user.Controller.Show"})
+	s.addRoute(&route{method: "GET", pattern: "/users/new", action: "user.Controller.New", comment: true})
+	s.addRoute(&route{method: "GET", pattern: "/users/:id/posts", action: "user.Controller.Posts"})
+	s.addRoute(&route{method: "GET", pattern: "/users/:id/*rest", action: "user.Controller.Rest"})
+	s.addRoute(&route{method: "GET", pattern: "/files/*path", action: "file.Controller.Serve", comment: true})
+	s.addRoute(&route{method: "POST", pattern: "/users", action: "user.Controller.Create"})
+
+	tests := []struct {
+		path   string
+		action string
+		params router.Param
+	}{
+		{"/", "home.Controller.Index", nil},
+		{"/users/new", "user.Controller.New", nil},
+		{"/users/5", "user.Controller.Show", router.Param{"id": "5"}},
+		{"/users/5/", "user.Controller.Show", router.Param{"id": "5"}},
+		{"/users/new/posts", "user.Controller.Posts", router.Param{"id": "new"}},
+		{"/users/5/a/b", "user.Controller.Rest", router.Param{"id": "5", "rest": "a/b"}},
+		{"/files", "file.Controller.Serve", router.Param{"path": ""}},
+		{"/files/css/app.css/", "file.Controller.Serve", router.Param{"path": "css/app.css"}},
+		{"/users", "", nil},
+		{"/users//posts", "", nil},
+		{"/posts", "", nil},
+	}
+	for _, test := range tests {
+		action, params, _, _, ok := s.match("GET", test.path)
+		if ok != (test.action != "") || action != test.action || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("match(%q) = %q, %v, %v, want %q, %v", test.path, action, params, ok, test.action, test.params)
+		}
+	}
+	if action, _, _, _, _ := s.match("POST", "/users/"); action != "user.Controller.Create" {
+		t.Errorf("match(POST /users/) = %q", action)
+	}
+}
+
+func TestMatchRouteAllocs(t *testing.T) {
+	s := newRouteServer(t)
+	s.addRoute(&route{method: "GET", pattern: "/users/:id/posts", action: "user.Controller.Posts"})
+	s.addRoute(&route{method: "GET", pattern: "/users/new", action: "user.Controller.New"})
+	allocs := testing.AllocsPerRun(100, func() {
+		if s.matchRoute("GET", "/users/5/posts") == nil {
+			t.Fatal("no route matched")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("matchRoute allocated %v times", allocs)
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	s := newRouteServer(t)
+	s.addRoute(&route{method: "GET", pattern: "/users/:id", action: "user.Controller.Show"})
+	s.addRoute(&route{method: "DELETE", pattern: "/users/:id", action: "user.Controller.Delete"})
+	want := []string{"GET", "DELETE", "HEAD", "OPTIONS"}
+	if got := s.AllowedMethods("/users/5"); !reflect.DeepEqual(got, want) {
+		t.Errorf("AllowedMethods() = %v, want %v", got, want)
+	}
+	if got := s.AllowedMethods("/posts"); got != nil {
+		t.Errorf("AllowedMethods() = %v, want nil", got)
+	}
+}
+
+func TestCheckRouteConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		routes   []*route
+		conflict bool
+	}{
+		{"static and param", []*route{
+			{method: "GET", pattern: "/users/:id", action: "user.Controller.Show"},
+			{method: "GET", pattern: "/users/new", action: "user.Controller.New", comment: true},
+		}, false},
+		{"code routes", []*route{
+			{method: "GET", pattern: "/users/:id", action: "user.Controller.Show"},
+			{method: "GET", pattern: "/users/:name/", action: "user.Controller.Find"},
+		}, true},
+		{"code and comment routes", []*route{
+			{method: "GET", pattern: "/users/:id", action: "user.Controller.Show"},
+			{method: "GET", pattern: "/users/:uid", action: "user.Controller.Profile", comment: true},
+		}, true},
+		{"comment routes of the same action", []*route{
+			{method: "GET", pattern: "/users/:id", action: "user.Controller.Show", comment: true},
+			{method: "GET", pattern: "/users/:id", action: "user.Controller.Show", comment: true},
+		}, false},
+		{"methods", []*route{
+			{method: "GET", pattern: "/users/:id", action: "user.Controller.Show"},
+			{method: "PUT", pattern: "/users/:id", action: "user.Controller.Update"},
+		}, false},
+	}
+	for _, test := range tests {
+		s := newRouteServer(t)
+		for _, rt := range test.routes {
+			s.addRoute(rt)
+		}
+		func() {
+			defer func() {
+				v := recover()
+				if conflict := v != nil; conflict != test.conflict {
+					t.Errorf("%s: conflict = %v, want %v", test.name, v, test.conflict)
+				} else if conflict && !strings.Contains(v.(error).Error(), "route conflicts") {
+					t.Errorf("%s: panic = %v", test.name, v)
+				}
+			}()
+			s.checkRouteConflicts()
+		}()
+	}
+}
+
+func TestAddRouteAfterBoot(t *testing.T) {
+	s := newRouteServer(t)
+	s.booted = true
+	defer func() {
+		if v := recover(); v == nil || !strings.Contains(v.(error).Error(), "before the server starts") {
+			t.Errorf("panic = %v", v)
+		}
+	}()
+	s.addRoute(&route{method: "GET", pattern: "/users", action: "user.Controller.Index"})
+}
+
+type methodController struct{}
+
+func (*methodController) Show(app *App) {
+	app.Response.Write([]byte("user " + app.Params["id"]))
+}
+
+func (methodController) Index(app *App) {}
+
+func TestControllerMethod(t *testing.T) {
+	tests := []struct {
+		action interface{}
+		name   string
+	}{
+		{(*methodController).Show, "Show"},
+		{(*methodController).Index, "Index"},
+		{func(*methodController, *App) {}, ""},
+		{methodController.Index, ""},
+		{new(methodController).Show, ""},
+		{nil, ""},
+	}
+	for i, test := range tests {
+		typ, name, err := controllerMethod(reflect.ValueOf(test.action))
+		if test.name == "" {
+			if err == nil {
+				t.Errorf("%d: %v.%s was accepted", i, typ, name)
+			}
+			continue
+		}
+		if err != nil || typ != reflect.TypeOf(methodController{}) || name != test.name {
+			t.Errorf("%d: controllerMethod() = %v, %q, %v, want %q", i, typ, name, err, test.name)
+		}
+	}
+}
+
+// newMethodServer returns the server which serves "GET /users/:id" and
+// "DELETE /users/:id".
+func newMethodServer(t *testing.T) *Server {
+	s := newRouteServer(t)
+	s.MContainer = middle.NewContainer(middle.NewMiddlewareBag(), service.NewPublicContainer())
+	s.injections = make(map[reflect.Type][]injection)
+	s.invokers = make(map[string]*invoker)
+	s.fileHandler = &defaultFileHandler{server: s}
+	s.notFoundHandler = &defaultNotFoundHandler{server: s}
+	controller := func() interface{} { return new(methodController) }
+	for _, method := range []string{"GET", "DELETE"} {
+		s.addRoute(&route{method: method, pattern: "/users/:id", action: "orivil.methodController.Show", controller: controller})
+	}
+	s.routePaths["orivil.methodController.Show"] = "/users/:id"
+	inv, err := s.newInvoker("orivil.methodController.Show", reflect.TypeOf(&methodController{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.invokers["orivil.methodController.Show"] = inv
+	return s
+}
+
+func TestServeMethods(t *testing.T) {
+	s := newMethodServer(t)
+	tests := []struct {
+		method, path string
+		code         int
+		allow        string
+		body         string
+	}{
+		{"GET", "/users/5", 200, "", "user 5"},
+		{"HEAD", "/users/5", 200, "", ""},
+		{"PUT", "/users/5", 405, "GET, DELETE, HEAD, OPTIONS", "405 Method Not Allowed"},
+		{"OPTIONS", "/users/5", 204, "GET, DELETE, HEAD, OPTIONS", ""},
+		{"PUT", "/posts/5", 404, "", "404 Not Found"},
+		{"OPTIONS", "/posts/5", 404, "", "404 Not Found"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.code || w.Header().Get("Allow") != test.allow {
+			t.Errorf("%s %s: status = %d, Allow = %q", test.method, test.path, w.Code, w.Header().Get("Allow"))
+		}
+		if body := w.Body.String(); test.body == "" && body != "" || !strings.Contains(body, test.body) {
+			t.Errorf("%s %s: body = %q, want %q", test.method, test.path, body, test.body)
+		}
+	}
+}
+
+func BenchmarkMatchRoute(b *testing.B) {
+	s := newRouteServer(b)
+	for _, p := range []string{"/", "/users", "/users/new", "/users/:id", "/users/:id/posts", "/posts/:id", "/files/*path"} {
+		s.addRoute(&route{method: "GET", pattern: p, action: "bench.Controller.Action"})
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.matchRoute("GET", "/users/5/posts")
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// URLKey is the view data key of the URL builder, e.g.
+//
+//	<a href="{{.url.Path "user.Controller.Show" "id" .user.ID}}">profile</a>
+//	<a href="{{.url.Abs "user.Controller.Show" "id" .user.ID}}">share</a>
+const URLKey = "url"
+
+// SetRoutePath sets the path pattern for building the URLs of the action, the
+// patterns of the "@route" comments are set when the server starts. The
+// pattern looks like "/users/:id" or "/files/*path".
+func (s *Server) SetRoutePath(action, pattern string) {
+
+	s.routePaths[action] = pattern
+}
+
+// RoutePath returns the path pattern of the action.
+func (s *Server) RoutePath(action string) (pattern string, ok bool) {
+	pattern, ok = s.routePaths[action]
+	return
+}
+
+// URL builds the URL path of the action, the params are name-value pairs, the
+// params which are not in the path are added to the query string. It panics if
+// the action has no route or a path param is missing.
+//
+// Usage:
+//
+//	// @route {get}/users/:id
+//	func (c *Controller) Show() {}
+//
+//	s.URL("user.Controller.Show", "id", 5, "tab", "posts") // "/users/5?tab=posts"
+func (s *Server) URL(action string, params ...interface{}) string {
+	u, err := s.BuildURL(action, params...)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// BuildURL is the same as URL, but returns the error instead of panic.
+func (s *Server) BuildURL(action string, params ...interface{}) (string, error) {
+	pattern, ok := s.routePaths[action]
+	if !ok {
+		return "", fmt.Errorf("build url: action %q has no route", action)
+	}
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("build url: odd number of params for action %q", action)
+	}
+	values := make(map[string]string, len(params)/2)
+	var names []string
+	for i := 0; i < len(params); i += 2 {
+		name := fmt.Sprint(params[i])
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		values[name] = fmt.Sprint(params[i+1])
+	}
+
+	segments := strings.Split(pattern, "/")
+	for i, seg := range segments {
+		var name string
+		var catchAll bool
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			name = strings.TrimLeft(seg, ":")
+		case strings.HasPrefix(seg, "*"):
+			name, catchAll = seg[1:], true
+		default:
+			continue
+		}
+		value, ok := values[name]
+		if !ok {
+			return "", fmt.Errorf("build url: missing param %q for action %q", name, action)
+		}
+		delete(values, name)
+		if catchAll {
+			parts := strings.Split(strings.TrimPrefix(value, "/"), "/")
+			for j, part := range parts {
+				parts[j] = url.PathEscape(part)
+			}
+			segments[i] = strings.Join(parts, "/")
+		} else {
+			segments[i] = url.PathEscape(value)
+		}
+	}
+	u := strings.Join(segments, "/")
+	if len(values) > 0 {
+		query := make(url.Values, len(values))
+		for _, name := range names {
+			if value, ok := values[name]; ok {
+				query.Set(name, value)
+			}
+		}
+		u += "?" + query.Encode()
+	}
+	return u, nil
+}
+
+// URLBuilder builds the URL paths and the absolute URLs of the actions for the
+// request, it is added to the view data by the "url" key.
+type URLBuilder struct {
+	server  *Server
+	request *http.Request
+}
+
+// Path builds the URL path, see Server.URL.
+func (b *URLBuilder) Path(action string, params ...interface{}) (string, error) {
+
+	return b.server.BuildURL(action, params...)
+}
+
+// Abs builds the absolute URL, the scheme and host are read from "BASE_URL"
+// config, or the request if it is not set. The request "Host" header is sent
+// by the client, so "BASE_URL" should be set outside debug mode, the server
+// warns when it starts if it is not.
+func (b *URLBuilder) Abs(action string, params ...interface{}) (string, error) {
+	p, err := b.server.BuildURL(action, params...)
+	if err != nil {
+		return "", err
+	}
+	return baseURL(b.request) + p, nil
+}
+
+// baseURLWarning returns the warning if "BASE_URL" is not set outside debug
+// mode, returns empty string if it is fine.
+func baseURLWarning() string {
+	if CfgApp.DEBUG || CfgApp.BASE_URL != "" {
+		return ""
+	}
+	return `"BASE_URL" is not set, the absolute URLs are built from the request "Host" header, which is sent by clients`
+}
+
+func baseURL(r *http.Request) string {
+	if CfgApp.BASE_URL != "" {
+		return strings.TrimSuffix(CfgApp.BASE_URL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// URL builds the URL path of the action, see Server.URL.
+func (app *App) URL(action string, params ...interface{}) string {
+
+	return app.Server.URL(action, params...)
+}
+
+// AbsURL builds the absolute URL of the action, see URLBuilder.Abs.
+func (app *App) AbsURL(action string, params ...interface{}) string {
+
+	return baseURL(app.Request) + app.Server.URL(action, params...)
+}
+
+// RedirectTo redirects to the URL of the action, see Server.URL.
+func (app *App) RedirectTo(action string, params ...interface{}) {
+
+	app.Redirect(app.Server.URL(action, params...))
+}
+
+// loadRouteComments reads the "@route" and "@timeout" comments and sets the
+// path patterns and the timeouts, the URL references are checked by
+// checkURLRefs. The patterns are joined with the controller
+// prefixes of the "RegRoute" methods, see ScanRoutes. The routes are added by
+// addCommentRoutes, the bundles of the route manifest are skipped. It panics if
+// any bundle could not be read, because its routes would not be matched. e.g.
+//
+//	c.Add("/admin", func() interface{} { return new(Controller) })
+//
+//	// @route {get}/users/:id
+//	func (c *Controller) Show() {} // "/admin/users/:id"
+func (s *Server) loadRouteComments(dir string) {
+	bundles, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var errs []string
+	for _, bundle := range bundles {
+		if !bundle.IsDir() || s.manifestCovers(bundle.Name()) {
+			continue
+		}
+		bundleDir := filepath.Join(dir, bundle.Name())
+		src, err := ScanRoutes(bundleDir)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		s.setTimeoutComments(bundleDir, src.Timeouts)
+		s.commentRoutes = append(s.commentRoutes, src.Routes...)
+		s.urlRefs = append(s.urlRefs, src.URLRefs...)
+		for _, r := range src.Routes {
+			// the first route of the action is used for building URLs
+			if _, ok := s.routePaths[r.Action]; !ok {
+				s.SetRoutePath(r.Action, r.Pattern)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		panic(fmt.Errorf("read @route comments:\n\t%s", strings.Join(errs, "\n\t")))
+	}
+}
+
+// the URL builder calls with an action literal in the views
+var viewURLRef = regexp.MustCompile(`\.url\.(?:Path|Abs)\s+"([^"]+)"`)
+
+// checkURLRefs checks the actions referenced by the URL builder in the bundle
+// views, and the action literals passed to URL, AbsURL and RedirectTo in the
+// bundle sources, see ScanRoutes. It panics if any action has no route, so the
+// broken links are found when the server starts rather than when the pages are
+// visited.
+func (s *Server) checkURLRefs() {
+	var missing []string
+	for _, ref := range s.urlRefs {
+		if s.routePaths[ref.Action] == "" {
+			missing = append(missing, fmt.Sprintf("%s: %s", ref.Pos, ref.Action))
+		}
+	}
+	check := func(file string, data []byte) {
+		for _, m := range viewURLRef.FindAllSubmatch(data, -1) {
+			if action := string(m[1]); s.routePaths[action] == "" {
+				missing = append(missing, fmt.Sprintf("%s: %s", file, action))
+			}
+		}
+	}
+	for _, r := range s.registers {
+		bundle := bundleName(r)
+		views := s.bundleFS(bundle, BundleView)
+		if views == nil {
+			views = os.DirFS(filepath.Join(DirBundle, bundle, BundleView))
+		}
+		fs.WalkDir(views, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if data, err := fs.ReadFile(views, name); err == nil {
+				check(filepath.Join(bundle, BundleView, name), data)
+			}
+			return nil
+		})
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		panic(fmt.Errorf("actions referenced by the URL builder have no route:\n\t%s", strings.Join(missing, "\n\t")))
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckURLRefs(t *testing.T) {
+	dir := writeBundle(t, map[string]string{"register.go": scanRegister, "controller.go": scanController, "links.go": scanLinks})
+	s := &Server{routePaths: make(map[string]string), routeTimeouts: make(map[string]time.Duration)}
+	s.loadRouteComments(filepath.Dir(dir))
+	s.SetRoutePath("home.Controller.Index", "/")
+	defer func() {
+		v := recover()
+		err, _ := v.(error)
+		if err == nil || !strings.Contains(err.Error(), "links.go:6:12: user.Controller.Missing") ||
+			strings.Contains(err.Error(), "Show") || strings.Contains(err.Error(), "Index") {
+			t.Errorf("panic = %v", v)
+		}
+	}()
+	s.checkURLRefs()
+}
+
+func TestBaseURLWarning(t *testing.T) {
+	debug, base := CfgApp.DEBUG, CfgApp.BASE_URL
+	defer func() { CfgApp.DEBUG, CfgApp.BASE_URL = debug, base }()
+	tests := []struct {
+		debug bool
+		base  string
+		warn  bool
+	}{
+		{false, "", true},
+		{false, "https://example.com", false},
+		{true, "", false},
+	}
+	for _, test := range tests {
+		CfgApp.DEBUG, CfgApp.BASE_URL = test.debug, test.base
+		if warn := baseURLWarning() != ""; warn != test.warn {
+			t.Errorf("debug = %v, BASE_URL = %q: warning = %v", test.debug, test.base, warn)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Command routegen scans the bundle sources once and generates the Go file of
+// the route manifest, which contains every "@route" comment route, controller
+// provider, action name and "@timeout" comment, so the production binary does
+// not need the bundle sources next to it.
+//
+// Usage:
+//
+//	//go:generate go run gopkg.in/orivil/orivil.v2/routegen -dir bundle -o routes_gen.go
+//
+//	server := orivil.NewServer(":8080")
+//	server.UseManifest(RouteManifest)
+//
+// The controllers are read from the "c.Add(prefix, provider)" calls in the
+// "RegRoute" methods of the bundle registers, the provider must return
+// "new(Controller)" or "&Controller{}", routegen fails on the calls which can
+// not be read, see orivil.ScanRoutes. The scanned bundles are listed in the
+// manifest, the server does not call their "RegRoute" methods.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"gopkg.in/orivil/orivil.v2"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	dir  = flag.String("dir", "bundle", "the bundle directory")
+	out  = flag.String("o", "routes_gen.go", "the output file")
+	pkg  = flag.String("pkg", "", "the package name of the output file, default is $GOPACKAGE or \"main\"")
+	name = flag.String("var", "RouteManifest", "the variable name of the manifest")
+)
+
+type bundle struct {
+	name       string
+	importPath string
+	alias      string
+	source     *orivil.RouteSource
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("routegen: ")
+	flag.Parse()
+	if *pkg == "" {
+		*pkg = os.Getenv("GOPACKAGE")
+	}
+	if *pkg == "" {
+		*pkg = "main"
+	}
+
+	bundles, err := parseBundles(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := generate(bundles)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+	routes := 0
+	for _, b := range bundles {
+		routes += len(b.source.Routes)
+	}
+	fmt.Printf("routegen: %d routes written to %s\n", routes, *out)
+}
+
+// parseBundles scans the comment routes of the bundle directories and resolves
+// their import paths by "go list".
+func parseBundles(dir string) ([]*bundle, error) {
+	paths, err := importPaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	return scanBundles(dir, paths)
+}
+
+// scanBundles scans the comment routes of the bundle directories, the paths are
+// the import paths of the absolute directories.
+func scanBundles(dir string, paths map[string]string) ([]*bundle, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var bundles []*bundle
+	aliases := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		b := &bundle{name: entry.Name()}
+		if b.source, err = orivil.ScanRoutes(filepath.Join(dir, b.name)); err != nil {
+			return nil, err
+		}
+		if b.source.Package == "" {
+			continue
+		}
+		abs, err := filepath.Abs(filepath.Join(dir, b.name))
+		if err != nil {
+			return nil, err
+		}
+		if b.importPath = paths[abs]; b.importPath == "" {
+			return nil, fmt.Errorf("can not resolve the import path of %s", abs)
+		}
+		b.alias = b.source.Package
+		for i := 2; aliases[b.alias] || b.alias == "orivil"; i++ {
+			b.alias = b.source.Package + strconv.Itoa(i)
+		}
+		aliases[b.alias] = true
+		bundles = append(bundles, b)
+	}
+	return bundles, nil
+}
+
+// importPaths returns the import paths of the packages under the directory,
+// the keys are the absolute directories.
+func importPaths(dir string) (map[string]string, error) {
+	pattern := filepath.ToSlash(filepath.Clean(dir)) + "/..."
+	if !filepath.IsAbs(dir) {
+		pattern = "./" + pattern
+	}
+	output, err := exec.Command("go", "list", "-e", "-f", "{{.Dir}}\t{{.ImportPath}}", pattern).Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list %s: %v", pattern, err)
+	}
+	paths := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if d, p, ok := strings.Cut(line, "\t"); ok {
+			paths[d] = p
+		}
+	}
+	return paths, nil
+}
+
+// generate returns the formatted source of the manifest file.
+func generate(bundles []*bundle) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by routegen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", *pkg)
+	fmt.Fprintf(&buf, "import (\n\t\"gopkg.in/orivil/orivil.v2\"\n")
+	for _, b := range bundles {
+		if len(b.source.Routes) > 0 {
+			fmt.Fprintf(&buf, "\t%s %q\n", b.alias, b.importPath)
+		}
+	}
+	fmt.Fprintf(&buf, ")\n\n")
+
+	fmt.Fprintf(&buf, "// %s contains the comment routes of the bundles in %q.\n", *name, filepath.ToSlash(*dir))
+	fmt.Fprintf(&buf, "var %s = &orivil.RouteManifest{\n", *name)
+	fmt.Fprintf(&buf, "Bundles: []string{")
+	for i, b := range bundles {
+		if i > 0 {
+			fmt.Fprintf(&buf, ", ")
+		}
+		fmt.Fprintf(&buf, "%q", b.name)
+	}
+	fmt.Fprintf(&buf, "},\n")
+	fmt.Fprintf(&buf, "Routes: []orivil.ManifestRoute{\n")
+	timeouts := make(map[string]string)
+	for _, b := range bundles {
+		for _, r := range b.source.Routes {
+			fmt.Fprintf(&buf, "{Method: %q, Pattern: %q, Action: %q, Controller: func() interface{} { return new(%s.%s) }},\n",
+				r.Method, r.Pattern, r.Action, b.alias, r.Controller)
+		}
+		for action, timeout := range b.source.Timeouts {
+			timeouts[action] = timeout
+		}
+	}
+	fmt.Fprintf(&buf, "},\n")
+	if len(timeouts) > 0 {
+		actions := make([]string, 0, len(timeouts))
+		for action := range timeouts {
+			actions = append(actions, action)
+		}
+		sort.Strings(actions)
+		fmt.Fprintf(&buf, "Timeouts: map[string]string{\n")
+		for _, action := range actions {
+			fmt.Fprintf(&buf, "%q: %q,\n", action, timeouts[action])
+		}
+		fmt.Fprintf(&buf, "},\n")
+	}
+	fmt.Fprintf(&buf, "}\n")
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const userRegister = `package user
+
+import "gopkg.in/orivil/router.v0"
+
+type Register struct{}
+
+func (*Register) RegRoute(c *router.Container) {
+	c.Add("/admin", func() interface{} {
+		return new(Controller)
+	})
+}
+`
+
+const userController = `package user
+
+type Controller struct{}
+
+// @route {get|post}/users/:id
+// @timeout 5s
+func (c *Controller) Show() {}
+`
+
+// writeBundles writes the bundle files under a temporary directory, the keys
+// are like "user/register.go", returns the directory and the import paths.
+func writeBundles(t *testing.T, files map[string]string) (string, map[string]string) {
+	dir := t.TempDir()
+	paths := make(map[string]string)
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths[filepath.Dir(file)] = "example.com/app/bundle/" + filepath.Base(filepath.Dir(file))
+	}
+	return dir, paths
+}
+
+func TestGenerate(t *testing.T) {
+	*pkg = "main"
+	dir, paths := writeBundles(t, map[string]string{
+		"user/register.go":   userRegister,
+		"user/controller.go": userController,
+		"home/home.go":       "package home\n",
+		"assets/app.css":     "",
+	})
+	bundles, err := scanBundles(dir, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundles) != 2 || bundles[0].name != "home" || bundles[1].name != "user" {
+		t.Fatalf("bundles = %v", bundles)
+	}
+	src, err := generate(bundles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`user "example.com/app/bundle/user"`,
+		`Bundles: []string{"home", "user"},`,
+		`{Method: "GET", Pattern: "/admin/users/:id", Action: "user.Controller.Show", Controller: func() interface{} { return new(user.Controller) }},`,
+		`{Method: "POST", Pattern: "/admin/users/:id", Action: "user.Controller.Show", Controller: func() interface{} { return new(user.Controller) }},`,
+		`"user.Controller.Show": "5s",`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("the manifest has no %s:\n%s", want, src)
+		}
+	}
+	if strings.Contains(string(src), "bundle/home") {
+		t.Errorf("the bundle without routes was imported:\n%s", src)
+	}
+}
+
+func TestScanBundlesUnreadableAdd(t *testing.T) {
+	dir, paths := writeBundles(t, map[string]string{
+		"user/register.go":   strings.Replace(userRegister, `"/admin"`, `prefix`, 1),
+		"user/controller.go": userController,
+	})
+	if _, err := scanBundles(dir, paths); err == nil || !strings.Contains(err.Error(), "can not read the controller") {
+		t.Errorf("scanBundles() error = %v", err)
+	}
+}
+
+func TestScanBundlesImportPath(t *testing.T) {
+	dir, _ := writeBundles(t, map[string]string{"user/controller.go": userController})
+	if _, err := scanBundles(dir, nil); err == nil || !strings.Contains(err.Error(), "import path") {
+		t.Errorf("scanBundles() error = %v", err)
+	}
+}
@@ -18,13 +18,11 @@ import (
 	"strings"
 	"time"
 	"gopkg.in/orivil/grace.v0"
+	"gopkg.in/orivil/log.v0"
+	"errors"
 	"io"
 	"os"
 	"fmt"
-	"strconv"
-	"net/url"
-	"bufio"
-	"html/template"
 
 	// import these packages for downloading them
 	_ "gopkg.in/orivil/xsrftoken.v0"
@@ -66,6 +64,35 @@ type Server struct {
 	registers       []Register
 	fileHandler     FileHandler
 	notFoundHandler NotFoundHandler
+	renderers       map[string]Renderer
+	mediaTypes      []string
+	formats         map[string]string
+	errorHandlers   map[string]map[int]ErrorHandler
+	routeTimeouts   map[string]time.Duration
+	timeoutType     string
+	timeoutBody     []byte
+	accessSinks     []AccessSink
+	accessFile      *RotatingFile
+	encoders        map[string]Encoder
+	encodings       []string
+	compressTypes   []string
+	assets          *Assets
+	viewDirs        map[string]string
+	viewTmp         string
+	staticPrefixes  []string
+	spaIndex        string
+	spaExcludes     []string
+	routes          []*route
+	trees           map[string]*routeNode
+	commentRoutes   []CommentRoute
+	urlRefs         []URLRef
+	manifest        *RouteManifest
+	serviceTypes    map[string]reflect.Type
+	interfaces      map[reflect.Type]string
+	injections      map[reflect.Type][]injection
+	invokers        map[string]*invoker
+	routePaths      map[string]string
+	booted          bool
 	*grace.GraceServer
 }
 
@@ -116,16 +143,58 @@ func NewServer(addr string) *Server {
 		RContainer: rContainer,
 		VContainer: combiner,
 		GraceServer: graceServer,
+		renderers: make(map[string]Renderer, 6),
+		formats: make(map[string]string, 7),
+		errorHandlers: make(map[string]map[int]ErrorHandler, 1),
+		routeTimeouts: make(map[string]time.Duration),
+		encoders: make(map[string]Encoder, 2),
+		assets: newAssets(),
+		viewDirs: make(map[string]string),
+		staticPrefixes: []string{"/"},
+		routePaths: make(map[string]string),
+		trees: make(map[string]*routeNode),
+		serviceTypes: make(map[string]reflect.Type),
+		interfaces: make(map[reflect.Type]string),
+		injections: make(map[reflect.Type][]injection),
+		invokers: make(map[string]*invoker),
 	}
 
 	server.Handler = server
 
 
 	// set default not found handler
-	server.notFoundHandler = &defaultNotFoundHandler{}
+	server.notFoundHandler = &defaultNotFoundHandler{server: server}
 
 	// set default static file server handler
-	server.fileHandler = &defaultFileHandler{}
+	server.fileHandler = &defaultFileHandler{server: server}
+
+	// set default response renderers
+	server.setDefaultRenderers()
+
+	// set default response encoders
+	server.setDefaultEncoders()
+
+	// declare the framework services for the controller injection, they are
+	// registered by BaseRegister, or cached by the app
+	server.declareService(SvcServer, (*Server)(nil))
+	server.declareService(SvcMemorySession, (*Session)(nil))
+	server.declareService(SvcPermanentSession, (*PSession)(nil))
+	server.declareService(SvcSessionContainer, (*service.Container)(nil))
+
+	// open the access log file if configured
+	if CfgApp.ACCESS_LOG != "" {
+		name := CfgApp.ACCESS_LOG
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(DirBase, name)
+		}
+		file, err := NewRotatingFile(name, int64(CfgApp.ACCESS_LOG_MAX_SIZE) << 20, CfgApp.ACCESS_LOG_BACKUPS)
+		if err != nil {
+			log.ErrWarnF("open access log: %v", err)
+		} else {
+			server.accessFile = file
+			server.AddAccessSink(NewAccessLog(file, CfgApp.ACCESS_LOG_FORMAT))
+		}
+	}
 
 	// register base service
 	server.RegisterBundle(
@@ -169,72 +238,118 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	start := time.Now()
 	path := r.URL.Path
-	// handle static file
-	if s.fileHandler.HandleFile(r) {
-		s.serveFile(w, r, path)
-	} else {
 
-		var app *App
+	var app *App
+
+	// the compressing writer is under the recording writer, so the status and
+	// the size are recorded before compressing, the counter under it records
+	// the compressed size for the access log
+	var counter *countWriter
+	cw := s.compress(w, r)
+	if cw != nil {
+		counter = &countWriter{ResponseWriter: w}
+		cw.ResponseWriter = counter
+		w = cw
+	}
+
+	// the writer records the status and the size
+	rw := newResponseWriter(w)
+	w = rw
+
+	// record the access entry after the response finished
+	if len(s.accessSinks) > 0 {
 		defer func() {
-			err := recover()
-			if app != nil {
-				s.storeSession(app)
-				for _, f := range app.defers {
-					f()
-				}
-			}
-			if err, ok := err.(error); ok {
-				handleError(w, r, app, err)
-			} else if err != nil {
-				panic(err)
+			size := rw.Size()
+			if counter != nil {
+				size = counter.n
 			}
+			s.logAccess(rw, r, app, start, size)
 		}()
+	}
 
-		path = r.Method + path
+	defer func() {
+		// every panic value is converted to *PanicError which carries the stack
+		var err error
+		if v := recover(); v != nil {
+			err = NewPanicError(v)
+		}
+		if app != nil {
+			s.storeSession(app)
+			for _, f := range app.defers {
+				f()
+			}
+		}
+		if err != nil {
+			if app != nil && (app.TimedOut() || errors.Is(err, http.ErrHandlerTimeout)) {
+				// the action was stopped by the timeout, the response was sent
+				if app.TimedOut() && !errors.Is(err, http.ErrHandlerTimeout) {
+					log.ErrWarnF("panic after action timeout: [ ACTION ]: %s [ ERROR ]: %v", app.Action, err)
+				}
+			} else {
+				s.handleError(w, r, app, err)
+			}
+		}
+		// the error handlers could still use the request context
+		if app != nil && app.cancel != nil {
+			app.cancel()
+		}
+		// waits for the running timeout response, the writer can not be used
+		// after the handler returned
+		rw.finish()
+		if cw != nil {
+			cw.Close()
+		}
+	}()
 
-		// match route
-		if action, params, controller, ok := s.RContainer.Match(path); !ok {
+	// handle static file, the file is resolved at most once for the request
+	static := &staticLookup{server: s, path: path}
+	if s.handleFile(r, static) {
+		s.serveFile(w, r, static)
+	} else {
 
-			s.notFoundHandler.NotFound(w, r)
-		} else {
-			// new private container
-			privateContainer := service.NewPrivateContainer(s.SContainer)
+		// match route
+		action, params, controller, routeMiddles, ok := s.match(r.Method, path)
 
-			// new app
-			app = &App{
-				Params:    params,
-				Action:    action,
-				Response:  w,
-				Request:   r,
-				Container: privateContainer,
-				VContainer: s.VContainer,
-				Server: s,
-				data:  make(map[string]interface{}, 1),
-				Start: start,
+		// serve "HEAD" from "GET" routes with the body suppressed
+		if !ok && r.Method == "HEAD" {
+			if action, params, controller, routeMiddles, ok = s.match("GET", path); ok {
+				rw.discardBody = true
 			}
+		}
 
-			// cache the orivil.App and orivil.Server to private container.
-			app.AddCache(SvcApp, app)
-			app.AddCache(SvcServer, s)
+		if !ok {
+			if allow := s.AllowedMethods(path); len(allow) > 0 {
+				w.Header().Set("Allow", strings.Join(allow, ", "))
+				if r.Method == "OPTIONS" {
+					w.WriteHeader(http.StatusNoContent)
+				} else {
+					s.handleError(w, r, nil, NewHTTPError(http.StatusMethodNotAllowed, ""))
+				}
+			} else if s.staticFallback(r, static) {
+				// the directory index or the bundle error
+				s.serveFile(w, r, static)
+			} else if s.serveSPA(w, r) {
+				// the SPA index was sent
+			} else {
+				s.notFoundHandler.NotFound(w, r)
+			}
+		} else {
+			// new app
+			app = s.newApp(w, r, action, params)
+			app.Start = start
+			app.initContext()
 
-			// match middleware
-			middleNames := s.MContainer.Get(action)
-			middles := make([]interface{}, len(middleNames))
+			// the action call prepared when the server started
+			inv := s.invoker(action)
 
 			// get middleware instances from private container
-			for index, service := range middleNames {
-				middles[index] = privateContainer.Get(service)
-			}
+			middles := inv.middlewares(app, routeMiddles)
 
 			// call middleware
 			s.callMiddles(middles, app)
 
 			// call controller action
-			value := reflect.ValueOf(controller())
-			s.setControllerDependence(value, app)
-			method := action[strings.LastIndex(action, ".") + 1:]
-			actionFun, _ := value.Type().MethodByName(method)
-			actionFun.Func.Call([]reflect.Value{value})
+			inv.call(controller(), app)
 
 			// call "Terminate" middleware
 			s.callMiddlesTerminate(middles, app)
@@ -245,67 +360,89 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, urlPath string) {
-	var filename string
-	if CfgApp.DEBUG {
-		q := r.URL.Query()
-		debug := q.Get("debug")
-		if debug == "true" {
-			line, err := strconv.Atoi(q.Get("line"))
-			if err != nil {
-				panic(err)
-			}
-			filename, err := url.QueryUnescape(urlPath)
-			if err != nil {
-				panic(err)
-			}
-			filename = filename[1:]
-			file, err := os.Open(filename)
-			if err != nil {
-				panic(err)
-			}
-			var lines []string
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				lines = append(lines, scanner.Text())
-			}
-			if err := scanner.Err(); err != nil {
-				panic(err)
-			}
-			tpl := template.New("debug")
-			tpl.Funcs(template.FuncMap{
-				"add": func(a, b int) int {
-					return a + b
-				},
-			})
-			_, err = tpl.Parse(debugFileTemplate)
-			if err != nil {
-				panic(err)
-			}
-			err = tpl.Execute(w, map[string]interface{}{
-				"lines": lines,
-				"line": line,
-			})
-			if err != nil {
-				panic(err)
+// newApp creates the app with a new private container.
+func (s *Server) newApp(w http.ResponseWriter, r *http.Request, action string, params router.Param) *App {
+	rw := newResponseWriter(w)
+	app := &App{
+		Params:     params,
+		Action:     action,
+		Response:   rw,
+		writer:     rw,
+		Request:    r,
+		Container:  service.NewPrivateContainer(s.SContainer),
+		VContainer: s.VContainer,
+		Server:     s,
+		data:       make(map[string]interface{}, 1),
+		Start:      time.Now(),
+	}
+
+	// cache the orivil.App and orivil.Server to private container.
+	app.AddCache(SvcApp, app)
+	app.AddCache(SvcServer, s)
+	return app
+}
+
+// the methods which AllowedMethods checks
+var routeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// AllowedMethods returns the methods which have routes matched the URL path,
+// "HEAD" and "OPTIONS" are included if the path has any route.
+func (s *Server) AllowedMethods(path string) (methods []string) {
+	var hasGet, hasHead, hasOptions bool
+	for _, method := range routeMethods {
+		if s.hasRoute(method, path) {
+			methods = append(methods, method)
+			switch method {
+			case "GET":
+				hasGet = true
+			case "HEAD":
+				hasHead = true
+			case "OPTIONS":
+				hasOptions = true
 			}
-			return
 		}
 	}
+	if hasGet && !hasHead {
+		methods = append(methods, "HEAD")
+	}
+	if len(methods) > 0 && !hasOptions {
+		methods = append(methods, "OPTIONS")
+	}
+	return
+}
+
+// serveFile serves the static file, the URL path is resolved to DirStaticFile,
+// or the "public" directory of a registered bundle if it starts with "/bundle-".
+// The hidden files and the files out of the root directory are not served.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, static *staticLookup) {
+	if isDebugRequest(r) {
+		s.serveDebugFile(w, r, static.path)
+		return
+	}
 
-	if strings.HasPrefix(urlPath, "/bundle-") {
-		str := strings.TrimPrefix(urlPath, "/bundle-")
-		firstIdx := strings.Index(str, "/")
-		var bundle string
-		if firstIdx > 1 && firstIdx < len(str) {
-			bundle = str[:firstIdx]
-			urlPath = str[firstIdx:]
+	f, err := static.resolve()
+	if err != nil {
+		s.handleError(w, r, nil, err)
+		return
+	}
+	if f == nil {
+		s.notFoundHandler.NotFound(w, r)
+		return
+	}
+
+	// the caching headers are only sent for the regular files
+	if f.regular {
+		s.setCacheHeaders(w, f.assetPath, f.fingerprinted)
+		// the embedded files have no modification time, validate them by hash
+		if hash := s.assets.Hash(f.assetPath); f.embedded && hash != "" && w.Header().Get("ETag") == "" {
+			w.Header().Set("ETag", `"`+hash+`"`)
 		}
-		filename = filepath.Join(DirBundle, bundle, "public", urlPath)
+	}
+	if f.embedded {
+		s.serveFS(w, r, f.fsys, f.name)
 	} else {
-		filename = filepath.Join(DirStaticFile, urlPath)
+		s.fileHandler.ServeFile(w, r, f.filename)
 	}
-	s.fileHandler.ServeFile(w, r, filename)
 }
 
 func (s *Server) storeSession(a *App) {
@@ -316,18 +453,6 @@ func (s *Server) storeSession(a *App) {
 	}
 }
 
-func (s *Server) setControllerDependence(controller reflect.Value, app *App) {
-	v := controller.Elem()
-	len := v.NumField()
-	for i := 0; i < len; i++ {
-		fi := v.Field(i)
-		if fi.CanSet() && fi.Type().String() == "*orivil.App" {
-			fi.Set(reflect.ValueOf(app))
-			break
-		}
-	}
-}
-
 func (s *Server) callMiddles(middles []interface{}, app *App) {
 	for _, middle := range middles {
 		switch mid := middle.(type) {
@@ -388,15 +513,48 @@ func (s *Server) RegisterBundle(r ...Register) {
 // Initialize all bundles
 func (s *Server) init() {
 
+	if s.manifest != nil {
+		// the comment routes were generated
+		s.loadManifest()
+	}
+
+	// read the "@route" and "@timeout" comments, except the bundles of the
+	// manifest
+	s.loadRouteComments(DirBundle)
+
+	// extract the embedded views, the temporary directory is removed by close,
+	// or here if the server could not start
+	if err := s.extractViews(); err != nil {
+		s.removeViews()
+		panic(err)
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			s.removeViews()
+			panic(v)
+		}
+	}()
+
+	// compute the static file hashes for fingerprinting
+	if !CfgApp.DEBUG {
+		if err := s.assets.load(s.publicRoots()); err != nil {
+			log.ErrWarnF("load static assets: %v", err)
+		}
+	}
+
 	// register services
 	for _, r := range s.registers {
 		r.RegService(s.SContainer)
 	}
 
-	// register routes
+	// register routes, the controllers of the manifest bundles are in the
+	// manifest
 	for _, r := range s.registers {
-		r.RegRoute(s.RContainer)
+		if !s.manifestCovers(bundleName(r)) {
+			r.RegRoute(s.RContainer)
+		}
 	}
+	s.addCommentRoutes()
 
 
 	// register middleware
@@ -404,7 +562,10 @@ func (s *Server) init() {
 		r.RegMiddle(s.MContainer)
 	}
 
-	allActions := s.RContainer.GetActions()
+	// report the conflicts of the code routes and the comment routes
+	s.checkRouteConflicts()
+
+	allActions, cProviders := s.allActions()
 	for bundle, controllers := range allActions {
 		for controller, actions := range controllers {
 			s.MiddleBag.AddController(bundle, controller, actions)
@@ -414,12 +575,11 @@ func (s *Server) init() {
 	// config middleware
 	for _, r := range s.registers {
 
-		bundle := filepath.Base(reflect.TypeOf(r).Elem().PkgPath())
+		bundle := bundleName(r)
 		s.MiddleBag.SetCurrent(bundle, "")
 		r.CfgMiddle(s.MiddleBag)
 	}
 
-	cProviders := s.RContainer.GetControllers()
 	for bundle, controllers := range allActions {
 		for controller, _ := range controllers {
 			c := cProviders[bundle + "." + controller]()
@@ -434,32 +594,74 @@ func (s *Server) init() {
 	for _, r := range s.registers {
 		r.Boot(s)
 	}
+
+	// check the actions referenced by the URL builder
+	s.checkURLRefs()
+	if msg := baseURLWarning(); msg != "" {
+		log.ErrWarn(msg)
+	}
+
+	// plan the controller injections, the services were declared
+	s.loadInjections(cProviders)
+
+	// prepare the action calls with the declared services and the configured
+	// middleware
+	s.loadActions(allActions, cProviders)
+
+	// the routes added later would have no prepared call
+	s.booted = true
 }
 
 func (s *Server) close() {
 	for _, r := range s.registers {
 		r.Close()
 	}
+	if s.accessFile != nil {
+		s.accessFile.Close()
+	}
+	s.removeViews()
 }
 
 // defaultFileHandler implements "FileHandler" interface for handling static files.
-type defaultFileHandler struct{}
+type defaultFileHandler struct {
+	server *Server
+}
+
+// HandleFile checks whether or not to handle the request as static file request,
+// the "GET" and "HEAD" requests are handled if the URL path has a static prefix
+// and the regular file exists, otherwise the request goes to the router.
+func (h *defaultFileHandler) HandleFile(r *http.Request) bool {
 
-// HandleFile checks whether or not to handle the request as static file request.
-func (s *defaultFileHandler) HandleFile(r *http.Request) bool {
-	return filepath.Ext(r.URL.Path) != ""
+	return h.handle(r, &staticLookup{server: h.server, path: r.URL.Path})
 }
 
-// ServeFile serves static file
-func (s *defaultFileHandler) ServeFile(w http.ResponseWriter, r *http.Request, name string) {
+func (h *defaultFileHandler) handle(r *http.Request, static *staticLookup) bool {
+	if isDebugRequest(r) {
+		return true
+	}
+	if r.Method != "GET" && r.Method != "HEAD" || !h.server.hasStaticPrefix(r.URL.Path) {
+		return false
+	}
+	f, _ := static.resolve()
+	return f != nil && f.regular
+}
+
+// ServeFile serves static file, the precompressed ".br" or ".gz" file is served
+// instead if "PRECOMPRESSED" is enabled and the file exists.
+func (h *defaultFileHandler) ServeFile(w http.ResponseWriter, r *http.Request, name string) {
+	if CfgApp.PRECOMPRESSED && servePrecompressed(w, r, os.DirFS(filepath.Dir(name)), filepath.Base(name)) {
+		return
+	}
 	http.ServeFile(w, r, name)
 }
 
-// implements NotFoundHandler interface
-type defaultNotFoundHandler struct{}
+// implements NotFoundHandler interface, it sends the 404 error by the error
+// handler if set.
+type defaultNotFoundHandler struct {
+	server *Server
+}
 
 func (h *defaultNotFoundHandler) NotFound(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(404)
-	w.Write(notFoundPage)
+
+	h.server.handleError(w, r, nil, NewHTTPError(http.StatusNotFound, ""))
 }
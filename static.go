@@ -0,0 +1,281 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the URL prefix of the bundle public files, e.g. "/bundle-home/css/app.css"
+const bundlePrefix = "/bundle-"
+
+// the hidden directory which is still served, e.g. for the ACME challenges
+const wellKnownDir = ".well-known"
+
+var errBundleNotFound = NewHTTPError(http.StatusNotFound, "bundle not found")
+
+// SetStaticPrefixes sets the URL path prefixes of the static files, the default
+// prefix is "/". The "GET" and "HEAD" requests under the prefixes are served as
+// static files if the files exist, otherwise they go to the router, so routes
+// like "/users/john.doe" still reach the controllers. The bundle public files
+// ("/bundle-<bundle>/...") are always static.
+//
+// Every "GET" and "HEAD" request under the prefixes checks the file system once
+// before routing, except the files indexed by Assets when not in debug mode,
+// so narrow prefixes save the check for the routes.
+//
+// Usage:
+//
+//	s.SetStaticPrefixes("/css/", "/js/", "/images/", "/favicon.ico")
+func (s *Server) SetStaticPrefixes(prefixes ...string) {
+
+	s.staticPrefixes = prefixes
+}
+
+// SetSPA enables the single page application mode, the index file(relative to
+// DirStaticFile) is sent for the "GET" and "HEAD" requests which accept HTML and
+// match no static file and no route, except the URL paths under the excludes.
+//
+// Usage:
+//
+//	s.SetSPA("index.html", "/api/")
+func (s *Server) SetSPA(index string, excludes ...string) {
+	s.spaIndex = index
+	s.spaExcludes = excludes
+}
+
+// hasStaticPrefix checks whether or not the URL path is under the static prefixes.
+func (s *Server) hasStaticPrefix(urlPath string) bool {
+	if strings.HasPrefix(urlPath, bundlePrefix) {
+		return true
+	}
+	for _, prefix := range s.staticPrefixes {
+		if strings.HasPrefix(urlPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// staticFile is the resolved static file.
+type staticFile struct {
+	fsys          fs.FS
+	name          string // the slash path in fsys
+	filename      string // the file path on disk, empty if embedded
+	embedded      bool
+	regular       bool
+	assetPath     string // the URL path without the fingerprint
+	fingerprinted bool
+}
+
+// resolveStatic resolves the URL path to the static file, returns nil if the
+// file can not be served, returns an error if the bundle is not registered.
+func (s *Server) resolveStatic(urlPath string) (*staticFile, error) {
+	f := &staticFile{}
+
+	// resolve the fingerprinted path
+	if original, ok := s.assets.original(urlPath); ok {
+		urlPath, f.fingerprinted = original, true
+	}
+	f.assetPath = urlPath
+
+	root := DirStaticFile
+	if strings.HasPrefix(urlPath, bundlePrefix) {
+		var bundle string
+		bundle, urlPath = splitBundlePath(urlPath)
+		if !s.hasBundle(bundle) {
+			return nil, errBundleNotFound
+		}
+		if fsys := s.bundleFS(bundle, BundlePublic); fsys != nil {
+			f.fsys, f.embedded = fsys, true
+		}
+		root = filepath.Join(DirBundle, bundle, BundlePublic)
+	}
+	if !f.embedded {
+		f.fsys = os.DirFS(root)
+	}
+
+	name, ok := cleanStaticPath(urlPath)
+	if !ok {
+		return nil, nil
+	}
+	f.name = name
+	if !f.embedded {
+		f.filename = filepath.Join(root, filepath.FromSlash(name))
+	}
+	if !CfgApp.DEBUG && s.assets.Hash(f.assetPath) != "" {
+		// the files indexed by Assets are regular and confined, they are served
+		// without checking the file system
+		f.regular = true
+		return f, nil
+	}
+	if ok, f.regular = servable(f.fsys, name); !ok {
+		return nil, nil
+	}
+	if !f.embedded && !confined(root, f.filename) {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// staticLookup resolves the static file of the request at most once, so the
+// file handler and serveFile share the result.
+type staticLookup struct {
+	server *Server
+	path   string
+	done   bool
+	file   *staticFile
+	err    error
+}
+
+func (l *staticLookup) resolve() (*staticFile, error) {
+	if !l.done {
+		l.done = true
+		l.file, l.err = l.server.resolveStatic(l.path)
+	}
+	return l.file, l.err
+}
+
+// handleFile checks whether or not to serve the request as a static file, the
+// default file handler shares the resolved file with serveFile.
+func (s *Server) handleFile(r *http.Request, static *staticLookup) bool {
+	if h, ok := s.fileHandler.(*defaultFileHandler); ok {
+		return h.handle(r, static)
+	}
+	return s.fileHandler.HandleFile(r)
+}
+
+// staticFallback checks whether or not the request which matched no route
+// should be served by serveFile, e.g. the directory which has "index.html" or
+// the file of an unknown bundle.
+func (s *Server) staticFallback(r *http.Request, static *staticLookup) bool {
+	if r.Method != "GET" && r.Method != "HEAD" || !s.hasStaticPrefix(r.URL.Path) {
+		return false
+	}
+	f, err := static.resolve()
+	return f != nil || err != nil
+}
+
+// serveSPA sends the SPA index file, returns false if the SPA mode is disabled
+// or the request is not an HTML page request.
+func (s *Server) serveSPA(w http.ResponseWriter, r *http.Request) bool {
+	if s.spaIndex == "" || r.Method != "GET" && r.Method != "HEAD" || !acceptsHTML(r) {
+		return false
+	}
+	for _, exclude := range s.spaExcludes {
+		if strings.HasPrefix(r.URL.Path, exclude) {
+			return false
+		}
+	}
+	f, err := os.Open(filepath.Join(DirStaticFile, s.spaIndex))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return false
+	}
+	// the index refers the fingerprinted assets, it must be revalidated
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	return true
+}
+
+// acceptsHTML checks whether or not the "Accept" header explicitly accepts HTML,
+// "*/*" is not counted, so the API clients do not get the SPA index.
+func acceptsHTML(r *http.Request) bool {
+	for _, spec := range parseAccept(r.Header.Get("Accept")) {
+		if spec.q > 0 && spec.match(MediaHTML) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasBundle checks whether or not the bundle was registered.
+func (s *Server) hasBundle(bundle string) bool {
+	for _, r := range s.registers {
+		if bundleName(r) == bundle {
+			return true
+		}
+	}
+	return false
+}
+
+// splitBundlePath splits "/bundle-home/css/app.css" to "home" and "/css/app.css".
+func splitBundlePath(urlPath string) (bundle, rest string) {
+	str := strings.TrimPrefix(urlPath, bundlePrefix)
+	if idx := strings.Index(str, "/"); idx >= 0 {
+		return str[:idx], str[idx:]
+	}
+	return str, "/"
+}
+
+// cleanStaticPath cleans the URL path and returns the relative slash path, it
+// returns false if any element of the path is hidden, except ".well-known".
+func cleanStaticPath(urlPath string) (string, bool) {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		return ".", true
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") && elem != wellKnownDir {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// servable checks whether or not the file exists and can be served, the
+// directories are only served if they have "index.html" or "STATIC_DIR_LISTING"
+// is enabled. Returns whether or not the file is a regular file.
+func servable(fsys fs.FS, name string) (ok, regular bool) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return false, false
+	}
+	if info.IsDir() {
+		if CfgApp.STATIC_DIR_LISTING {
+			return true, false
+		}
+		_, err := fs.Stat(fsys, path.Join(name, "index.html"))
+		return err == nil, false
+	}
+	return info.Mode().IsRegular(), info.Mode().IsRegular()
+}
+
+// confined checks whether or not the file is under the root directory after
+// the symbolic links were resolved.
+func confined(root, filename string) bool {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	real, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return false
+	}
+	return withinDir(realRoot, real)
+}
+
+// withinDir checks whether or not the path is the dir or under the dir.
+func withinDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// serveFS serves the file of the embedded public directory.
+func (s *Server) serveFS(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) {
+	if CfgApp.PRECOMPRESSED && servePrecompressed(w, r, fsys, name) {
+		return
+	}
+	http.ServeFileFS(w, r, fsys, name)
+}
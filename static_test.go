@@ -0,0 +1,69 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newStaticServer(t *testing.T) *Server {
+	dir := t.TempDir()
+	old := DirStaticFile
+	DirStaticFile = dir
+	t.Cleanup(func() { DirStaticFile = old })
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	os.WriteFile(filepath.Join(dir, "css", "app.css"), []byte("body{}"), 0644)
+	os.WriteFile(filepath.Join(dir, "robots.txt"), []byte("User-agent: *"), 0644)
+	s := &Server{assets: newAssets(), staticPrefixes: []string{"/"}}
+	s.fileHandler = &defaultFileHandler{server: s}
+	return s
+}
+
+func TestResolveStaticIndexed(t *testing.T) {
+	s := newStaticServer(t)
+	debug := CfgApp.DEBUG
+	CfgApp.DEBUG = false
+	defer func() { CfgApp.DEBUG = debug }()
+	if err := s.assets.load(s.publicRoots()); err != nil {
+		t.Fatal(err)
+	}
+	// the indexed files are resolved without the file system
+	os.RemoveAll(DirStaticFile)
+	for _, p := range []string{"/css/app.css", s.assets.URL("/css/app.css")} {
+		f, err := s.resolveStatic(p)
+		if err != nil || f == nil || !f.regular || f.assetPath != "/css/app.css" {
+			t.Errorf("resolveStatic(%q) = %+v, %v", p, f, err)
+		}
+	}
+	if f, _ := s.resolveStatic("/users/1"); f != nil {
+		t.Errorf("the unknown path was resolved: %+v", f)
+	}
+}
+
+func TestResolveStaticOnce(t *testing.T) {
+	s := newStaticServer(t)
+	r := httptest.NewRequest("GET", "/robots.txt", nil)
+	static := &staticLookup{server: s, path: r.URL.Path}
+	if !s.handleFile(r, static) {
+		t.Fatalf("the static file was not handled")
+	}
+	f, _ := static.resolve()
+	// the removed file is still the resolved result of the request
+	os.Remove(filepath.Join(DirStaticFile, "robots.txt"))
+	if again, _ := static.resolve(); again != f {
+		t.Errorf("the file was resolved again")
+	}
+	if s.fileHandler.HandleFile(r) {
+		t.Errorf("the removed file was handled by a new lookup")
+	}
+
+	r = httptest.NewRequest("POST", "/css/app.css", nil)
+	if s.handleFile(r, &staticLookup{server: s, path: r.URL.Path}) {
+		t.Errorf("the POST request was handled as a static file")
+	}
+}
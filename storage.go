@@ -0,0 +1,379 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StoredFile is the metadata of a stored upload file, controllers can persist
+// it as the upload record.
+type StoredFile struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"` // the client file name
+	ContentType string `json:"contentType"`
+	Path        string `json:"path"` // the stored file path or object key
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"` // hex encoded
+}
+
+// UploadStorage stores the upload files, the storage should remove the partial
+// file if it got any error.
+type UploadStorage interface {
+	Store(src io.Reader, file *UploadFile) (*StoredFile, error)
+}
+
+// StoreFiles streams the upload files to the storage, see StreamFiles.
+//
+// Usage:
+//
+//	storage := orivil.NewDirStorage("./uploads")
+//	files, err := app.StoreFiles(limits, storage)
+func (app *App) StoreFiles(limits *UploadLimits, storage UploadStorage) (files []*StoredFile, err error) {
+	err = app.StreamFiles(limits, func(src io.Reader, file *UploadFile) error {
+		stored, err := storage.Store(src, file)
+		if err != nil {
+			return err
+		}
+		files = append(files, stored)
+		return nil
+	})
+	return
+}
+
+// NewFileStorage adapts the storage to the FileStorage callback of FormFiles,
+// the stored callback receives the metadata of each stored file.
+func NewFileStorage(storage UploadStorage, stored func(*StoredFile)) FileStorage {
+	return func(srcFile multipart.File, name string) error {
+		f, err := storage.Store(srcFile, &UploadFile{Filename: filepath.Base(name)})
+		if err != nil {
+			return err
+		}
+		if stored != nil {
+			stored(f)
+		}
+		return nil
+	}
+}
+
+// DirStorage stores files in the local directory with sanitized and unique names,
+// e.g. "my photo.png" is stored as "my_photo.png", "my_photo-1.png"...
+type DirStorage struct {
+	Dir  string
+	Perm os.FileMode
+}
+
+func NewDirStorage(dir string) *DirStorage {
+
+	return &DirStorage{Dir: dir, Perm: 0644}
+}
+
+func (s *DirStorage) Store(src io.Reader, file *UploadFile) (*StoredFile, error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return nil, err
+	}
+	name := SanitizeFilename(file.Filename)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	var dst *os.File
+	var err error
+	for i := 0; ; i++ {
+		if i > 0 {
+			name = base + "-" + strconv.Itoa(i) + ext
+		}
+		dst, err = os.OpenFile(filepath.Join(s.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, s.Perm)
+		if !os.IsExist(err) || i >= 1000 {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored, err := copyAndHash(dst, src, file)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return nil, err
+	}
+	stored.Path = dst.Name()
+	return stored, nil
+}
+
+// HashStorage stores files by their SHA-256 hex sum, the same content will be
+// stored only once, e.g. "uploads/3a/3a7bd3e2360a3d...png".
+type HashStorage struct {
+	Dir  string
+	Perm os.FileMode
+}
+
+func NewHashStorage(dir string) *HashStorage {
+
+	return &HashStorage{Dir: dir, Perm: 0644}
+}
+
+func (s *HashStorage) Store(src io.Reader, file *UploadFile) (*StoredFile, error) {
+	tmp, stored, err := spoolFile(s.Dir, src, file)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(s.Dir, stored.SHA256[:2])
+	name := filepath.Join(dir, stored.SHA256+strings.ToLower(filepath.Ext(SanitizeFilename(file.Filename))))
+	if err = os.MkdirAll(dir, 0755); err == nil {
+		if _, statErr := os.Stat(name); statErr == nil {
+			// already stored
+			os.Remove(tmp)
+		} else if err = os.Rename(tmp, name); err == nil {
+			err = os.Chmod(name, s.Perm)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	stored.Path = name
+	return stored, nil
+}
+
+// S3Storage stores files to the Amazon S3 compatible object storage, such as
+// MinIO for local development.
+type S3Storage struct {
+	// e.g. "https://s3.amazonaws.com" or "http://127.0.0.1:9000"
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// the object key prefix, e.g. "uploads/"
+	Prefix string
+	// use "endpoint/bucket/key" instead of "bucket.endpoint/key"
+	PathStyle bool
+	// the temporary directory for spooling files, default is os.TempDir()
+	TempDir string
+	// Key generates the object key without prefix, default is the SHA-256 sum with
+	// the file extension.
+	Key    func(file *UploadFile, sum string) string
+	Client *http.Client
+}
+
+func NewS3Storage(endpoint, region, bucket, accessKey, secretKey string) *S3Storage {
+	return &S3Storage{
+		Endpoint:  endpoint,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PathStyle: true,
+		Client:    http.DefaultClient,
+	}
+}
+
+// Store spools the file to a temporary file for getting its size and sum, then
+// puts it to the bucket with AWS signature version 4.
+func (s *S3Storage) Store(src io.Reader, file *UploadFile) (*StoredFile, error) {
+	tmpDir := s.TempDir
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+	tmp, stored, err := spoolFile(tmpDir, src, file)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	var key string
+	if s.Key != nil {
+		key = s.Key(file, stored.SHA256)
+	} else {
+		key = stored.SHA256 + strings.ToLower(filepath.Ext(SanitizeFilename(file.Filename)))
+	}
+	key = s.Prefix + key
+
+	body, err := os.Open(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	req, err := s.newPutRequest(key, body, stored)
+	if err != nil {
+		return nil, err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("s3: put object %q got status %d: %s", key, resp.StatusCode, msg)
+	}
+	stored.Path = key
+	return stored, nil
+}
+
+func (s *S3Storage) newPutRequest(key string, body io.Reader, stored *StoredFile) (*http.Request, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	// the key is not cleaned, "a//b", "../b" and "a/" are different objects
+	u := *endpoint
+	u.Path = strings.TrimSuffix(endpoint.Path, "/") + "/"
+	if s.PathStyle {
+		u.Path += s.Bucket + "/"
+	} else {
+		u.Host = s.Bucket + "." + endpoint.Host
+	}
+	u.Path += key
+	u.RawPath = s3EscapePath(u.Path)
+	req, err := http.NewRequest("PUT", u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = stored.Size
+	if stored.ContentType != "" {
+		req.Header.Set("Content-Type", stored.ContentType)
+	}
+	s.sign(req, stored.SHA256, time.Now().UTC())
+	return req, nil
+}
+
+// sign signs the request with AWS signature version 4.
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// s3EscapePath encodes each segment of the path as the canonical URI of
+// signature version 4, only the RFC 3986 unreserved characters are kept.
+func s3EscapePath(p string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// SanitizeFilename returns a safe base file name, only letters, digits, "-",
+// "_" and "." are kept, the others are replaced by "_".
+func SanitizeFilename(name string) string {
+	name = filepath.Base(strings.Replace(name, "\\", "/", -1))
+	rs := []rune(name)
+	for i, r := range rs {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			rs[i] = '_'
+		}
+	}
+	name = strings.TrimLeft(string(rs), ".")
+	if name == "" || name == "_" {
+		name = randomName()
+	}
+	return name
+}
+
+func randomName() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// spoolFile copies the src to a temporary file under the dir, and returns the
+// temporary file name.
+func spoolFile(dir string, src io.Reader, file *UploadFile) (tmp string, stored *StoredFile, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	f, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return
+	}
+	stored, err = copyAndHash(f, src, file)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), stored, nil
+}
+
+func copyAndHash(dst io.Writer, src io.Reader, file *UploadFile) (*StoredFile, error) {
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, h), src)
+	if err != nil {
+		return nil, err
+	}
+	return &StoredFile{
+		Field:       file.Field,
+		Filename:    file.Filename,
+		ContentType: file.ContentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
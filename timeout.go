@@ -0,0 +1,197 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"context"
+	"gopkg.in/orivil/log.v0"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Timeout is the middleware for setting the action timeout, so the timeout can
+// be configured by middle.Bag.
+//
+// Usage:
+//
+//	// register the middleware
+//	c.Add("api.Timeout", func(c *service.Container) interface{} {
+//		return orivil.Timeout(5 * time.Second)
+//	}, 0)
+//
+//	// configure the middleware
+//	bag.Set("api.Timeout").OnlyActions("Report")
+//
+// Or use the action comment:
+//
+//	// @route {get}/report
+//	// @timeout 5s
+//	func (c *Controller) Report() {}
+//
+// The "@timeout" comments are read from the bundle sources when the server
+// starts, so the sources must be deployed with the binary, or generate the
+// route manifest by routegen, see Server.UseManifest. The comments are ignored
+// silently if the bundle directory does not exist.
+type Timeout time.Duration
+
+func (t Timeout) Handle(app *App) {
+
+	app.SetTimeout(time.Duration(t))
+}
+
+// SetTimeoutBody sets the response body which is sent when the action timeout,
+// the default body is the 503 problem details or error page.
+func (s *Server) SetTimeoutBody(contentType string, body []byte) {
+	s.timeoutType = contentType
+	s.timeoutBody = body
+}
+
+// SetTimeout resets the action deadline to "app.Start + d", zero duration
+// means no deadline. It does nothing if the action is already timeout.
+func (app *App) SetTimeout(d time.Duration) {
+	if tc, ok := app.baseCtx.(*timeoutContext); ok {
+		var deadline time.Time
+		if d > 0 {
+			deadline = app.Start.Add(d)
+		}
+		tc.setDeadline(deadline)
+	}
+}
+
+// TimedOut reports whether or not the timeout response was sent, it is false
+// if the request context was done for other reasons, e.g. the client left, or
+// the response was already written when the action timeout.
+func (app *App) TimedOut() bool {
+
+	return app.writer != nil && app.writer.timeoutSent()
+}
+
+// timeoutContext is a cancelable context whose deadline can be reset until it
+// is done, it calls the onTimeout callback when the deadline exceeded.
+type timeoutContext struct {
+	context.Context
+	mu        sync.Mutex
+	deadline  time.Time
+	timer     *time.Timer
+	done      chan struct{}
+	err       error
+	onTimeout func()
+}
+
+func newTimeoutContext(parent context.Context, onTimeout func()) *timeoutContext {
+	tc := &timeoutContext{Context: parent, done: make(chan struct{}), onTimeout: onTimeout}
+	if parent.Done() != nil {
+		go func() {
+			select {
+			case <-parent.Done():
+				tc.finish(parent.Err())
+			case <-tc.done:
+			}
+		}()
+	}
+	return tc
+}
+
+func (c *timeoutContext) Deadline() (deadline time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline, ok = c.Context.Deadline()
+	if !c.deadline.IsZero() && (!ok || c.deadline.Before(deadline)) {
+		deadline, ok = c.deadline, true
+	}
+	return
+}
+
+func (c *timeoutContext) Done() <-chan struct{} {
+
+	return c.done
+}
+
+func (c *timeoutContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *timeoutContext) setDeadline(deadline time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.deadline = deadline
+	if !deadline.IsZero() {
+		c.timer = time.AfterFunc(time.Until(deadline), func() {
+			if c.finish(context.DeadlineExceeded) && c.onTimeout != nil {
+				c.onTimeout()
+			}
+		})
+	}
+}
+
+func (c *timeoutContext) cancel() {
+
+	c.finish(context.Canceled)
+}
+
+// finish closes the done channel, returns false if it was already done.
+func (c *timeoutContext) finish(err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return false
+	}
+	c.err = err
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	close(c.done)
+	return true
+}
+
+// onTimeout writes the 503 response if nothing was written, and logs the action.
+// It runs in the timer goroutine, so it must not touch the app.
+func (s *Server) onTimeout(w *responseWriter, r *http.Request, action, id string, start time.Time) {
+	wrote := w.timeout(func(rw http.ResponseWriter) {
+		if s.timeoutBody != nil {
+			rw.Header().Set("Content-Type", s.timeoutType)
+			rw.Header().Set("Content-Length", strconv.Itoa(len(s.timeoutBody)))
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			rw.Write(s.timeoutBody)
+		} else {
+			e := NewHTTPError(http.StatusServiceUnavailable, "")
+			if wantsProblem(r) {
+				writeProblem(rw, r, e)
+			} else {
+				writeErrorPage(rw, e)
+			}
+		}
+	})
+	log.ErrWarnF("action timeout: [ ACTION ]: %s [ URL ]: %s [ REQUEST ID ]: %s [ COST ]: %v [ 503 SENT ]: %v",
+		action, r.URL.String(), id, time.Since(start), wrote)
+}
+
+// setTimeoutComments sets the route timeouts of the "@timeout" comments, e.g.
+//
+//	// @timeout 5s
+//	func (c *Controller) Report() {}
+//
+// The bad durations are logged and skipped.
+func (s *Server) setTimeoutComments(source string, timeouts map[string]string) {
+	for action, value := range timeouts {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.ErrWarnF("%s: bad @timeout comment of %s: %v", source, action, err)
+			continue
+		}
+		s.SetRouteTimeout(action, d)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTimeoutApp returns the app of the action whose timeout is d, the request
+// context is ctx.
+func newTimeoutApp(ctx context.Context, w http.ResponseWriter, d time.Duration) *App {
+	s := &Server{routeTimeouts: make(map[string]time.Duration)}
+	s.SetRouteTimeout("user.Controller.Report", d)
+	r := httptest.NewRequest("GET", "/report", nil).WithContext(ctx)
+	app := s.newApp(w, r, "user.Controller.Report", nil)
+	app.initContext()
+	return app
+}
+
+// waitTimedOut waits until the app timed out, or the wait is long enough.
+func waitTimedOut(app *App) bool {
+	<-app.baseCtx.Done()
+	for i := 0; i < 100 && !app.TimedOut(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	return app.TimedOut()
+}
+
+func TestTimedOut(t *testing.T) {
+	w := httptest.NewRecorder()
+	app := newTimeoutApp(context.Background(), w, 10*time.Millisecond)
+	defer app.cancel()
+	if !waitTimedOut(app) {
+		t.Fatal("the action did not time out")
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", w.Code)
+	}
+	if _, err := app.Response.Write([]byte("late")); err != http.ErrHandlerTimeout {
+		t.Errorf("late write error = %v", err)
+	}
+}
+
+func TestTimedOutWritten(t *testing.T) {
+	w := httptest.NewRecorder()
+	app := newTimeoutApp(context.Background(), w, 10*time.Millisecond)
+	defer app.cancel()
+	app.Response.Write([]byte("partial"))
+	if waitTimedOut(app) {
+		t.Error("TimedOut() = true, but the response was written before")
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("status = %d, body = %q", w.Code, w.Body.String())
+	}
+}
+
+func TestTimedOutParentDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	w := httptest.NewRecorder()
+	app := newTimeoutApp(ctx, w, time.Minute)
+	defer app.cancel()
+	if waitTimedOut(app) {
+		t.Error("TimedOut() = true, but the parent context was done")
+	}
+	if app.baseCtx.Err() != context.DeadlineExceeded || w.Body.Len() != 0 {
+		t.Errorf("context error = %v, body = %q", app.baseCtx.Err(), w.Body.String())
+	}
+}
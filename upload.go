@@ -0,0 +1,245 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+)
+
+// upload errors, they are wrapped by *UploadError
+var (
+	ErrUploadTotalTooLarge  = errors.New("upload body too large")
+	ErrUploadValueTooLarge  = errors.New("upload form values too large")
+	ErrUploadTypeNotAllowed = errors.New("upload file type not allowed")
+	ErrUploadExtNotAllowed  = errors.New("upload file extension not allowed")
+	ErrNotMultipart         = errors.New("request is not multipart/form-data")
+)
+
+// UploadLimits limits the streaming uploads, zero values mean no limit.
+type UploadLimits struct {
+	// max bytes of each file
+	MaxFileSize int64
+	// max bytes of the whole request body
+	MaxTotalSize int64
+	// max bytes of all non-file form values, default is 10MB, the values over it
+	// report ErrUploadValueTooLarge
+	MaxValueSize int64
+	// allowed sniffed content types, e.g. "image/png" or "image/*"
+	AllowedTypes []string
+	// allowed file extensions, e.g. ".jpg"
+	AllowedExts []string
+	// denied file extensions, e.g. ".exe"
+	DeniedExts []string
+}
+
+// UploadFile describes one uploading file part.
+type UploadFile struct {
+	Field    string
+	Filename string
+	// the content type sniffed from the file content
+	ContentType string
+	// the content type declared by the client
+	DeclaredType string
+	Header       textproto.MIMEHeader
+}
+
+// StreamStorage defines the callback which how to store the streaming file,
+// src returns an *UploadError if the file is over the limits while reading,
+// the storage should remove the partial file if it got any error.
+type StreamStorage func(src io.Reader, file *UploadFile) error
+
+// UploadError reports which field and file broke the upload.
+type UploadError struct {
+	Field    string
+	Filename string
+	Err      error
+}
+
+func (e *UploadError) Error() string {
+
+	return "upload field " + e.Field + " (" + e.Filename + "): " + e.Err.Error()
+}
+
+func (e *UploadError) Unwrap() error {
+
+	return e.Err
+}
+
+// StreamFiles reads the multipart body part by part, and hands each file to
+// the store as it arrives, nothing is buffered in memory or temp files except
+// the first 512 bytes for sniffing the content type. The non-file values are
+// added to the form values, see App.Form.
+//
+// Usage:
+//
+//	limits := &orivil.UploadLimits{
+//		MaxFileSize:  2 << 20,
+//		MaxTotalSize: 20 << 20,
+//		AllowedTypes: []string{"image/*"},
+//	}
+//	err := app.StreamFiles(limits, func(src io.Reader, f *orivil.UploadFile) error {
+//		...
+//	})
+//	if e, ok := err.(*orivil.UploadError); ok {
+//		app.Danger(e.Field + ": " + e.Err.Error())
+//	}
+func (app *App) StreamFiles(limits *UploadLimits, store StreamStorage) error {
+	if limits == nil {
+		limits = &UploadLimits{}
+	}
+	r := app.Request
+	if limits.MaxTotalSize > 0 {
+		r.Body = http.MaxBytesReader(app.Response, r.Body, limits.MaxTotalSize)
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		if err == http.ErrNotMultipart {
+			return ErrNotMultipart
+		}
+		return err
+	}
+
+	if err = app.parseForm(); err != nil {
+		return err
+	}
+	form := app.form
+	valueSize := limits.MaxValueSize
+	if valueSize <= 0 {
+		valueSize = 10 << 20
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return uploadError("", "", err)
+		}
+
+		if part.FileName() == "" {
+			// non-file value
+			value, err := io.ReadAll(io.LimitReader(part, valueSize+1))
+			if err == nil && int64(len(value)) > valueSize {
+				part.Close()
+				return &UploadError{Field: part.FormName(), Err: ErrUploadValueTooLarge}
+			}
+			if err != nil {
+				part.Close()
+				return uploadError(part.FormName(), "", err)
+			}
+			valueSize -= int64(len(value))
+			form.Add(part.FormName(), string(value))
+			part.Close()
+			continue
+		}
+
+		file := &UploadFile{
+			Field:        part.FormName(),
+			Filename:     filepath.Base(part.FileName()),
+			DeclaredType: part.Header.Get("Content-Type"),
+			Header:       part.Header,
+		}
+		if !limits.extAllowed(file.Filename) {
+			part.Close()
+			return &UploadError{Field: file.Field, Filename: file.Filename, Err: ErrUploadExtNotAllowed}
+		}
+
+		src := &limitedPart{part: part, file: file, max: limits.MaxFileSize}
+		buf := bufio.NewReaderSize(src, 512)
+		head, err := buf.Peek(512)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			part.Close()
+			return uploadError(file.Field, file.Filename, err)
+		}
+		file.ContentType = http.DetectContentType(head)
+		if !limits.typeAllowed(file.ContentType) {
+			part.Close()
+			return &UploadError{Field: file.Field, Filename: file.Filename, Err: ErrUploadTypeNotAllowed}
+		}
+
+		err = store(buf, file)
+		part.Close()
+		if err != nil {
+			return uploadError(file.Field, file.Filename, err)
+		}
+	}
+}
+
+// uploadError converts the "request body too large" error to *UploadError,
+// other errors are returned directly.
+func uploadError(field, filename string, err error) error {
+	var e *UploadError
+	if errors.As(err, &e) {
+		return e
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return &UploadError{Field: field, Filename: filename, Err: ErrUploadTotalTooLarge}
+	}
+	return err
+}
+
+// limitedPart reads the file part and reports ErrUploadFileTooLarge once the
+// file is over the max size.
+type limitedPart struct {
+	part io.Reader
+	file *UploadFile
+	max  int64
+	read int64
+}
+
+func (p *limitedPart) Read(b []byte) (n int, err error) {
+	n, err = p.part.Read(b)
+	p.read += int64(n)
+	if p.max > 0 && p.read > p.max {
+		return n, &UploadError{Field: p.file.Field, Filename: p.file.Filename, Err: ErrUploadFileTooLarge}
+	}
+	if err != nil && err != io.EOF {
+		err = uploadError(p.file.Field, p.file.Filename, err)
+	}
+	return n, err
+}
+
+func (l *UploadLimits) extAllowed(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, denied := range l.DeniedExts {
+		if strings.ToLower(denied) == ext {
+			return false
+		}
+	}
+	if len(l.AllowedExts) == 0 {
+		return true
+	}
+	for _, allowed := range l.AllowedExts {
+		if strings.ToLower(allowed) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *UploadLimits) typeAllowed(contentType string) bool {
+	if len(l.AllowedTypes) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range l.AllowedTypes {
+		if allowed == mediaType || allowed == "*/*" ||
+			strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, allowed[:len(allowed)-1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+// Copyright 2016 orivil Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package orivil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// uploadPart is the multipart part, the value is a file if the filename is set.
+type uploadPart struct {
+	field, filename, content string
+}
+
+// newMultipartApp returns the app of the multipart request with the parts.
+func newMultipartApp(t *testing.T, parts ...uploadPart) *App {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, p := range parts {
+		if p.filename == "" {
+			w.WriteField(p.field, p.content)
+			continue
+		}
+		fw, err := w.CreateFormFile(p.field, p.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, p.content)
+	}
+	w.Close()
+	r := httptest.NewRequest("POST", "/upload", &body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	return &App{Request: r, Response: httptest.NewRecorder()}
+}
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+func TestStreamFiles(t *testing.T) {
+	app := newMultipartApp(t,
+		uploadPart{"title", "", "hello"},
+		uploadPart{"photo", "../photo.png", pngHeader + "0123456789"},
+		uploadPart{"notes", "notes.txt", "some notes"},
+	)
+	stored := make(map[string]string)
+	var files []*UploadFile
+	err := app.StreamFiles(&UploadLimits{MaxFileSize: 18, AllowedTypes: []string{"image/png", "text/*"}}, func(src io.Reader, f *UploadFile) error {
+		data, err := io.ReadAll(src)
+		stored[f.Filename] = string(data)
+		files = append(files, f)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Field != "photo" || files[0].Filename != "photo.png" ||
+		files[0].ContentType != "image/png" || files[1].ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("files = %+v", files)
+	}
+	if stored["photo.png"] != pngHeader+"0123456789" || stored["notes.txt"] != "some notes" {
+		t.Errorf("stored = %q", stored)
+	}
+	if got := app.form.Get("title"); got != "hello" {
+		t.Errorf("form value = %q, want %q", got, "hello")
+	}
+}
+
+func TestStreamFilesLimits(t *testing.T) {
+	big := strings.Repeat("a", 1<<10)
+	tests := []struct {
+		name   string
+		parts  []uploadPart
+		limits *UploadLimits
+		field  string
+		want   error
+	}{
+		{"file", []uploadPart{{"photo", "photo.png", big}}, &UploadLimits{MaxFileSize: 100}, "photo", ErrUploadFileTooLarge},
+		{"total", []uploadPart{{"photo", "photo.png", big}}, &UploadLimits{MaxTotalSize: 600}, "photo", ErrUploadTotalTooLarge},
+		{"value", []uploadPart{{"title", "", big}}, &UploadLimits{MaxValueSize: 10}, "title", ErrUploadValueTooLarge},
+		{"ext", []uploadPart{{"photo", "photo.exe", big}}, &UploadLimits{DeniedExts: []string{".EXE"}}, "photo", ErrUploadExtNotAllowed},
+		{"not allowed ext", []uploadPart{{"photo", "photo.gif", big}}, &UploadLimits{AllowedExts: []string{".png"}}, "photo", ErrUploadExtNotAllowed},
+		// the declared name and type do not matter, the content is sniffed
+		{"sniffed type", []uploadPart{{"photo", "photo.png", "<html><body>x</body></html>"}}, &UploadLimits{AllowedTypes: []string{"image/*"}}, "photo", ErrUploadTypeNotAllowed},
+		{"exact type", []uploadPart{{"photo", "photo.png", "GIF89a" + big}}, &UploadLimits{AllowedTypes: []string{"image/png"}}, "photo", ErrUploadTypeNotAllowed},
+	}
+	for _, test := range tests {
+		app := newMultipartApp(t, test.parts...)
+		err := app.StreamFiles(test.limits, func(src io.Reader, f *UploadFile) error {
+			_, err := io.Copy(io.Discard, src)
+			return err
+		})
+		var e *UploadError
+		if !errors.As(err, &e) || e.Err != test.want || e.Field != test.field {
+			t.Errorf("%s: StreamFiles() error = %v, want %v of field %q", test.name, err, test.want, test.field)
+		}
+	}
+}
+
+func TestStreamFilesAbort(t *testing.T) {
+	app := newMultipartApp(t,
+		uploadPart{"first", "first.txt", strings.Repeat("a", 1<<10)},
+		uploadPart{"second", "second.txt", "b"},
+	)
+	errFull := errors.New("storage full")
+	var calls []string
+	err := app.StreamFiles(nil, func(src io.Reader, f *UploadFile) error {
+		calls = append(calls, f.Field)
+		io.ReadFull(src, make([]byte, 100))
+		return errFull
+	})
+	if err != errFull {
+		t.Errorf("StreamFiles() error = %v, want %v", err, errFull)
+	}
+	// the rest of the parts are not handed to the store
+	if len(calls) != 1 || calls[0] != "first" {
+		t.Errorf("store calls = %v, want [first]", calls)
+	}
+}
+
+func TestStreamFilesNotMultipart(t *testing.T) {
+	r := httptest.NewRequest("POST", "/upload", strings.NewReader("a=b"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	app := &App{Request: r, Response: httptest.NewRecorder()}
+	if err := app.StreamFiles(nil, nil); err != ErrNotMultipart {
+		t.Errorf("StreamFiles() error = %v, want %v", err, ErrNotMultipart)
+	}
+}